@@ -18,6 +18,45 @@ import (
 func NewOryAuth(
 	mountPath, namespace, object, relation, cookie string,
 ) (*OryAuth, error) {
+	a, err := newOryAuth(mountPath, namespace, object, relation)
+	if err != nil {
+		return nil, err
+	}
+
+	if cookie == "" {
+		return nil, errors.New("no cookie provided")
+	}
+
+	a.cookie = cookie
+
+	return a, nil
+}
+
+// NewOryAuthWithSessionToken creates an OryAuth struct that authenticates with a
+// Kratos session token rather than a session cookie.
+//
+// The mount path, namespace, object, and relation are the same as for NewOryAuth.
+// The token should be the session token issued by a Kratos native (API) flow,
+// which is sent to Kratos in the `X-Session-Token` header.
+func NewOryAuthWithSessionToken(
+	mountPath, namespace, object, relation, token string,
+) (*OryAuth, error) {
+	a, err := newOryAuth(mountPath, namespace, object, relation)
+	if err != nil {
+		return nil, err
+	}
+
+	if token == "" {
+		return nil, errors.New("no session token provided")
+	}
+
+	a.token = token
+
+	return a, nil
+}
+
+// newOryAuth validates the fields common to every OryAuth variant.
+func newOryAuth(mountPath, namespace, object, relation string) (*OryAuth, error) {
 	switch {
 	case mountPath == "":
 		return nil, errors.New("no mount path provided")
@@ -27,8 +66,6 @@ func NewOryAuth(
 		return nil, errors.New("no object provided")
 	case relation == "":
 		return nil, errors.New("no relation provided")
-	case cookie == "":
-		return nil, errors.New("no cookie provided")
 	}
 
 	return &OryAuth{
@@ -36,7 +73,6 @@ func NewOryAuth(
 		namespace: namespace,
 		object:    object,
 		relation:  relation,
-		cookie:    cookie,
 	}, nil
 }
 
@@ -48,6 +84,7 @@ type OryAuth struct {
 	object    string
 	relation  string
 	cookie    string
+	token     string
 }
 
 // Login performs a login request to the Ory Vault auth plugin.
@@ -56,10 +93,14 @@ func (a *OryAuth) Login(ctx context.Context, client *api.Client) (*api.Secret, e
 		ctx = context.Background()
 	}
 	loginData := map[string]interface{}{
-		"namespace":             a.namespace,
-		"object":                a.object,
-		"relation":              a.relation,
-		"kratos_session_cookie": a.cookie,
+		"namespace": a.namespace,
+		"object":    a.object,
+		"relation":  a.relation,
+	}
+	if a.token != "" {
+		loginData["kratos_session_token"] = a.token
+	} else {
+		loginData["kratos_session_cookie"] = a.cookie
 	}
 	path := fmt.Sprintf("auth/%s/login", a.mountPath)
 	resp, err := client.Logical().WriteWithContext(ctx, path, loginData)
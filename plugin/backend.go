@@ -3,14 +3,16 @@ package plugin
 import (
 	"context"
 	"sync"
+	"time"
 
 	"github.com/comnoco/vault-plugin-auth-ory/version"
 
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/logical"
-	keto "github.com/ory/keto/proto/ory/keto/relation_tuples/v1alpha2"
 	kratos "github.com/ory/kratos-client-go"
+	"github.com/pkg/errors"
 	"google.golang.org/grpc"
+	jose "gopkg.in/square/go-jose.v2"
 )
 
 const (
@@ -37,17 +39,44 @@ type OryAuthBackend struct {
 	kratosClient      *kratos.APIClient
 	kratosClientMutex sync.RWMutex
 
+	sessionValidator      sessionValidator
+	sessionValidatorMutex sync.Mutex
+
+	// sessionValidatorRetryAt is when the Kratos API generation is detected again, if it could not be detected.
+	sessionValidatorRetryAt time.Time
+
+	// sessionValidatorGeneration is incremented whenever the session validator is discarded.
+	sessionValidatorGeneration uint64
+
+	kratosBreaker      *circuitBreaker
+	kratosBreakerMutex sync.Mutex
+
+	kratosAdminClient      *kratos.APIClient
+	kratosAdminClientMutex sync.RWMutex
+
 	ketoClient      *KetoClient
 	ketoClientMutex sync.RWMutex
+
+	hydraClient      *HydraClient
+	hydraClientMutex sync.RWMutex
+
+	jwks      *jose.JSONWebKeySet
+	jwksMutex sync.RWMutex
+
+	// jwksRefreshedAt is when the JWKS was last loaded, or a refresh for an unknown key ID last claimed.
+	jwksRefreshedAt time.Time
 }
 
 // KetoClient is a client for the Ory Keto API.
 type KetoClient struct {
-	// conn is the gRPC connection to the Keto API.
+	// checker checks relation tuples with the configured transport.
+	checker relationChecker
+
+	// conn is the gRPC connection to the Keto read API, if the gRPC transport is used.
 	conn *grpc.ClientConn
 
-	// CheckServiceClient is the client for the Keto Check API.
-	CheckServiceClient keto.CheckServiceClient
+	// readTimeout is the deadline of each call to the Keto read API.
+	readTimeout time.Duration
 }
 
 // NewBackend returns a new instance of the Ory-backed auth backend.
@@ -59,15 +88,17 @@ func NewBackend() *OryAuthBackend {
 		BackendType:    logical.TypeCredential,
 		Invalidate:     b.invalidateHandler,
 		PeriodicFunc:   b.periodicHandler,
-		// AuthRenew:    b.authRenewHandler,
-		Help: help,
+		AuthRenew:      b.authRenewHandler,
+		Help:           help,
 		PathsSpecial: &logical.Paths{
 			Unauthenticated: []string{"login"},
 			SealWrapStorage: []string{"config"},
 		},
 		Paths: framework.PathAppend(
 			NewPathConfig(b),
+			NewPathRole(b),
 			NewPathLogin(b),
+			NewPathHealth(b),
 		),
 	}
 
@@ -81,7 +112,11 @@ func (b *OryAuthBackend) Close() {
 	b.Logger().Debug("closing backend")
 
 	b.closeKratosClient()
+	b.closeKratosAdminClient()
+	b.closeKratosBreaker()
 	b.closeKetoClient()
+	b.closeHydraClient()
+	b.closeJWKS()
 
 	b.Logger().Debug("closed backend")
 }
@@ -98,7 +133,19 @@ func (b *OryAuthBackend) invalidateHandler(_ context.Context, key string) {
 
 // periodicHandler is called periodically to perform any backend tasks.
 func (b *OryAuthBackend) periodicHandler(ctx context.Context, req *logical.Request) error {
-	// TODO implement periodic handler (not necessarily a health check like below)
+	config, err := b.readConfig(ctx, req.Storage)
+	if err != nil {
+		return err
+	}
+
+	if config != nil && config.JWTJWKSURL != "" && config.JWTJWKS == "" {
+		b.Logger().Debug("running periodic jwks refresh")
+
+		_, err = b.refreshJWKS(ctx, req.Storage)
+		if err != nil {
+			return errors.Wrap(err, "failed to refresh jwks")
+		}
+	}
 
 	// b.Logger().Debug("running periodic healthCheck")
 
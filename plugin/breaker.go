@@ -0,0 +1,129 @@
+package plugin
+
+import (
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/pkg/errors"
+)
+
+const (
+	// circuitClosed lets every request through.
+	circuitClosed = "closed"
+
+	// circuitOpen fails every request fast until the cooldown has passed.
+	circuitOpen = "open"
+
+	// circuitHalfOpen lets a single trial request through to probe whether the service has recovered.
+	circuitHalfOpen = "half-open"
+)
+
+// errCircuitOpen is returned instead of making a request while the circuit breaker is open.
+var errCircuitOpen = errors.New("circuit breaker is open")
+
+// circuitBreaker stops requests to an unhealthy service after a number of consecutive failures,
+// and lets a trial request through once a cooldown has passed.
+type circuitBreaker struct {
+	mu sync.Mutex
+
+	// name is the name of the service, used in logs.
+	name string
+
+	// logger logs the state transitions of the circuit breaker.
+	logger hclog.Logger
+
+	// threshold is the number of consecutive failures that opens the circuit, or zero to never open it.
+	threshold int
+
+	// cooldown is how long the circuit stays open before a trial request is let through.
+	cooldown time.Duration
+
+	state    string
+	failures int
+	openedAt time.Time
+}
+
+// newCircuitBreaker returns a closed circuit breaker for the named service.
+func newCircuitBreaker(name string, logger hclog.Logger, threshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{
+		name:      name,
+		logger:    logger,
+		threshold: threshold,
+		cooldown:  cooldown,
+		state:     circuitClosed,
+	}
+}
+
+// allow returns errCircuitOpen if a request must not be made.
+func (c *circuitBreaker) allow() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch c.state {
+	case circuitOpen:
+		if time.Since(c.openedAt) < c.cooldown {
+			return errors.Wrapf(errCircuitOpen, "%s is unavailable", c.name)
+		}
+
+		c.transition(circuitHalfOpen)
+
+		return nil
+	case circuitHalfOpen:
+		// only the trial request is let through
+		return errors.Wrapf(errCircuitOpen, "%s is unavailable", c.name)
+	default:
+		return nil
+	}
+}
+
+// record records the outcome of a request that was allowed.
+func (c *circuitBreaker) record(success bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if success {
+		c.failures = 0
+		if c.state != circuitClosed {
+			c.transition(circuitClosed)
+		}
+
+		return
+	}
+
+	c.failures++
+
+	if c.state == circuitHalfOpen || (c.threshold > 0 && c.failures >= c.threshold) {
+		c.openedAt = time.Now()
+		if c.state != circuitOpen {
+			c.transition(circuitOpen)
+		}
+	}
+}
+
+// abandon records that the caller gave up on a request that was allowed, which says nothing about the
+// health of the service. A trial request is let through again immediately.
+func (c *circuitBreaker) abandon() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.state == circuitHalfOpen {
+		c.openedAt = time.Now().Add(-c.cooldown)
+		c.transition(circuitOpen)
+	}
+}
+
+// status returns the state of the circuit breaker and the number of consecutive failures.
+func (c *circuitBreaker) status() (string, int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.state, c.failures
+}
+
+// transition changes the state of the circuit breaker and logs it. The mutex must be held.
+func (c *circuitBreaker) transition(state string) {
+	c.logger.Warn("circuit breaker changed state", "service", c.name, "from", c.state, "to", state, "failures", c.failures)
+
+	c.state = state
+}
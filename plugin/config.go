@@ -2,10 +2,10 @@ package plugin
 
 import (
 	"context"
+	"crypto/tls"
 	"net/http"
 
 	"github.com/hashicorp/vault/sdk/logical"
-	keto "github.com/ory/keto-client-go/client"
 	kratos "github.com/ory/kratos-client-go"
 	"github.com/pkg/errors"
 )
@@ -16,11 +16,59 @@ type Config struct {
 	UseSessionExpiryTTL bool `json:"use_session_expiry_ttl,omitempty"`
 	TTLSeconds          int  `json:"ttl_seconds,omitempty"`
 	MaxTTLSeconds       int  `json:"max_ttl_seconds,omitempty"`
+	RequireRole         bool `json:"require_role,omitempty"`
+	AllowPartialTuples  bool `json:"allow_partial_tuples,omitempty"`
 
-	// Keto encapsulates the keto config (not currently supported)
+	// MaxListedObjects is the maximum number of objects granted by a login without an object, which
+	// lists the objects the subject has the relation to, or zero to disable such logins
+	MaxListedObjects int `json:"max_listed_objects,omitempty"`
+
+	// RequiredAAL is the minimum Kratos authenticator assurance level required to log in, which
+	// NamespaceRequiredAAL raises for specific namespaces
+	RequiredAAL          string            `json:"required_aal,omitempty"`
+	NamespaceRequiredAAL map[string]string `json:"namespace_required_aal,omitempty"`
+
+	// MaxSessionAge is the maximum time in seconds since a Kratos session was authenticated for it to
+	// be allowed to log in, which NamespaceMaxSessionAge lowers for specific namespaces
+	MaxSessionAge          int            `json:"max_session_age,omitempty"`
+	NamespaceMaxSessionAge map[string]int `json:"namespace_max_session_age,omitempty"`
+
+	// SessionClockSkew is the time in seconds by which the clocks of Vault and Kratos may disagree
+	// when checking the expiry and issue time of a Kratos session
+	SessionClockSkew int `json:"session_clock_skew,omitempty"`
+
+	// identity requirements checked against the identity of a Kratos session
+	RequireActiveIdentity      bool   `json:"require_active_identity,omitempty"`
+	RequireVerifiedAddress     bool   `json:"require_verified_address,omitempty"`
+	RequiredVerifiedAddressVia string `json:"required_verified_address_via,omitempty"`
+
+	// AllowedSchemaIDs restricts logins to Kratos identities of these schemas
+	AllowedSchemaIDs []string `json:"allowed_schema_ids,omitempty"`
+
+	// GroupRelations are the relations, in the format `namespace#relation`, whose subject sets are groups
+	// that logins are given group aliases for
+	GroupRelations []string `json:"group_relations,omitempty"`
+
+	// AliasNameSource determines what the entity alias is named after
+	AliasNameSource string `json:"alias_name_source,omitempty"`
+
+	// IdentityPoliciesPath is the path of the policies in the Kratos identity, which are granted
+	// if they match AllowedIdentityPolicies
+	IdentityPoliciesPath    string   `json:"identity_policies_path,omitempty"`
+	AllowedIdentityPolicies []string `json:"allowed_identity_policies,omitempty"`
+
+	// TraitMetadata maps Kratos identity trait paths to alias metadata keys
+	TraitMetadata map[string]string `json:"trait_metadata,omitempty"`
+
+	// RelationHierarchy maps a namespace to its relations, ordered from highest to lowest
+	RelationHierarchy map[string][]string `json:"relation_hierarchy,omitempty"`
+
+	// Keto encapsulates the keto config
+	Keto *KetoConfig `json:"keto,omitempty"`
+
+	// KetoHost is the Keto read address of configs stored before the keto config was nested, which
+	// readConfig moves into Keto
 	KetoHost string `json:"keto_host,omitempty"`
-	// TODO implement full keto config
-	// Keto     *KetoConfig `json:"keto,omitempty"`
 
 	// Kratos encapsulates the kratos config (not currently supported)
 	KratosURL           string            `json:"kratos_url,omitempty"`
@@ -28,8 +76,49 @@ type Config struct {
 	KratosUserAgent     string            `json:"kratos_user_agent,omitempty"`
 	KratosDefaultHeader map[string]string `json:"kratos_default_header,omitempty"`
 	KratosDebug         bool              `json:"kratos_debug,omitempty"`
+
+	// KratosAPIVersion selects the generation of the Kratos API used to validate sessions
+	KratosAPIVersion string `json:"kratos_api_version,omitempty"`
+
+	// KratosResilience encapsulates the timeouts, retries and circuit breaking of requests to Kratos
+	KratosTimeoutSeconds                int  `json:"kratos_timeout,omitempty"`
+	KratosMaxRetries                    *int `json:"kratos_max_retries,omitempty"`
+	KratosCircuitBreakerThreshold       *int `json:"kratos_circuit_breaker_threshold,omitempty"`
+	KratosCircuitBreakerCooldownSeconds int  `json:"kratos_circuit_breaker_cooldown,omitempty"`
+
+	// KratosTLS encapsulates the TLS config of the Kratos HTTP client
+	KratosCACert        string `json:"kratos_ca_cert,omitempty"`
+	KratosClientCert    string `json:"kratos_client_cert,omitempty"`
+	KratosClientKey     string `json:"kratos_client_key,omitempty"`
+	KratosTLSServerName string `json:"kratos_tls_server_name,omitempty"`
+	KratosTLSMinVersion string `json:"kratos_tls_min_version,omitempty"`
+	KratosTLSSkipVerify bool   `json:"kratos_tls_skip_verify,omitempty"`
+
+	// OryProject encapsulates the config of an Ory Network project, whose URL is used for the Kratos and
+	// Keto APIs unless they are configured, and whose API key authenticates admin and Keto requests
+	OryProjectSlug string `json:"ory_project_slug,omitempty"`
+	OryProjectURL  string `json:"ory_project_url,omitempty"`
+	OryAPIKey      string `json:"ory_api_key,omitempty"`
+
+	// KratosAdmin encapsulates the config of the Kratos admin API used to fetch full identities
+	KratosAdminURL    string `json:"kratos_admin_url,omitempty"`
+	KratosAdminAPIKey string `json:"kratos_admin_api_key,omitempty"`
+
 	// TODO implement full kratos config
 	// Kratos              *KratosConfig     `json:"kratos,omitempty"`
+
+	// Hydra encapsulates the hydra config used for access token introspection
+	HydraAdminURL       string   `json:"hydra_admin_url,omitempty"`
+	HydraClientID       string   `json:"hydra_client_id,omitempty"`
+	HydraClientSecret   string   `json:"hydra_client_secret,omitempty"`
+	HydraRequiredScopes []string `json:"hydra_required_scopes,omitempty"`
+
+	// JWT encapsulates the config used to verify Kratos tokenized sessions and Oathkeeper id_tokens
+	JWTJWKS         string   `json:"jwt_jwks,omitempty"`
+	JWTJWKSURL      string   `json:"jwt_jwks_url,omitempty"`
+	JWTIssuer       string   `json:"jwt_issuer,omitempty"`
+	JWTAudiences    []string `json:"jwt_audiences,omitempty"`
+	JWTSubjectClaim string   `json:"jwt_subject_claim,omitempty"`
 }
 
 // ServerVariable stores the information about a server variable.
@@ -60,9 +149,28 @@ type KratosConfig struct {
 	OperationServers map[string]ServerConfigurations `json:"operation_servers,omitempty"`
 }
 
-// KetoConfig stores the configuration of the Keto API client.
+// KetoConfig stores the configuration of the Keto API clients. The read and write APIs are served on
+// different ports and can be deployed separately.
 type KetoConfig struct {
-	TransportConfig *keto.TransportConfig `json:"transport_config,omitempty"`
+	// ReadAddr is the gRPC address of the read API, used to check and list relation tuples
+	ReadAddr string `json:"read_addr,omitempty"`
+
+	// WriteAddr is the gRPC address of the write API, which is not dialled as the plugin does not
+	// write relation tuples
+	WriteAddr string `json:"write_addr,omitempty"`
+
+	// transport of both APIs, which is only plaintext if Plaintext is set
+	CACert        string `json:"ca_cert,omitempty"`
+	ClientCert    string `json:"client_cert,omitempty"`
+	ClientKey     string `json:"client_key,omitempty"`
+	TLSServerName string `json:"tls_server_name,omitempty"`
+	Plaintext     bool   `json:"plaintext,omitempty"`
+
+	// Transport selects whether relation tuples are checked over gRPC (the default) or REST
+	Transport string `json:"transport,omitempty"`
+
+	// ReadTimeoutSeconds is the deadline of each call to the read API
+	ReadTimeoutSeconds int `json:"read_timeout,omitempty"`
 }
 
 // TransportConfig contains the transport related info.
@@ -92,6 +200,8 @@ func (b *OryAuthBackend) readConfig(ctx context.Context, s logical.Storage) (*Co
 		return nil, errors.Wrap(err, "error decoding config JSON")
 	}
 
+	config.migrateKetoHost()
+
 	b.Logger().Debug("successfully read config")
 
 	return config, nil
@@ -120,12 +230,21 @@ func (b *OryAuthBackend) setConfig(ctx context.Context, s logical.Storage, confi
 }
 
 // configToKratosConfig converts the plugin configuration to the Kratos API client configuration.
-func (b *OryAuthBackend) configToKratosConfig(config *Config) *kratos.Configuration {
+func (b *OryAuthBackend) configToKratosConfig(config *Config) (*kratos.Configuration, error) {
 	b.Logger().Debug("converting to kratos config")
 
 	if config == nil {
 		b.Logger().Error("nil config passed to kratos config transformer, using default")
-		return kratos.NewConfiguration()
+		return kratos.NewConfiguration(), nil
+	}
+
+	tlsConfig, err := kratosTLSConfig(config)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid kratos TLS config")
+	}
+
+	if config.KratosTLSSkipVerify {
+		b.Logger().Warn("kratos TLS certificate verification is disabled")
 	}
 
 	kratosConfig := kratos.NewConfiguration()
@@ -139,13 +258,50 @@ func (b *OryAuthBackend) configToKratosConfig(config *Config) *kratos.Configurat
 
 	kratosConfig.Servers = kratos.ServerConfigurations{
 		kratos.ServerConfiguration{
-			URL:         config.KratosURL,
+			URL:         config.kratosURL(),
 			Description: config.KratosDescription,
 			Variables:   make(map[string]kratos.ServerVariable),
 		},
 	}
 
-	kratosConfig.HTTPClient = &http.Client{}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+
+	kratosConfig.HTTPClient = &http.Client{
+		Transport: transport,
+		Timeout:   config.kratosTimeout(),
+	}
+
+	return kratosConfig, nil
+}
+
+// kratosTLSConfig returns the TLS config of the Kratos HTTP client.
+func kratosTLSConfig(config *Config) (*tls.Config, error) {
+	return newTLSConfig(tlsSettings{
+		caCert:     config.KratosCACert,
+		clientCert: config.KratosClientCert,
+		clientKey:  config.KratosClientKey,
+		serverName: config.KratosTLSServerName,
+		minVersion: config.KratosTLSMinVersion,
+		skipVerify: config.KratosTLSSkipVerify,
+	})
+}
+
+// configToKratosAdminConfig converts the plugin configuration to the configuration of a Kratos API client
+// for the admin API.
+func (b *OryAuthBackend) configToKratosAdminConfig(config *Config) (*kratos.Configuration, error) {
+	kratosConfig, err := b.configToKratosConfig(config)
+	if err != nil || config == nil {
+		return kratosConfig, err
+	}
+
+	kratosConfig.Servers = kratos.ServerConfigurations{
+		kratos.ServerConfiguration{
+			URL:         config.kratosAdminURL(),
+			Description: config.KratosDescription,
+			Variables:   make(map[string]kratos.ServerVariable),
+		},
+	}
 
-	return kratosConfig
+	return kratosConfig, nil
 }
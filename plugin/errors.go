@@ -0,0 +1,100 @@
+package plugin
+
+import (
+	"net/http"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/pkg/errors"
+)
+
+const (
+	// errCodeBadRequest means the login request is malformed, e.g. a field is missing.
+	errCodeBadRequest = "bad_request"
+
+	// errCodeSessionInvalid means the credential was rejected, e.g. the Kratos session expired.
+	errCodeSessionInvalid = "session_invalid"
+
+	// errCodeRelationDenied means the caller does not have the requested relations.
+	errCodeRelationDenied = "relation_denied"
+
+	// errCodeUpstreamUnavailable means an Ory service could not be reached or failed.
+	errCodeUpstreamUnavailable = "upstream_unavailable"
+)
+
+// loginError is a login failure with a stable error code, which prefixes its message so that clients
+// can tell why the login failed.
+type loginError struct {
+	code   string
+	status int
+	err    error
+}
+
+// Error returns the message of the error, prefixed with its code.
+func (e *loginError) Error() string {
+	return e.code + ": " + e.err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *loginError) Unwrap() error {
+	return e.err
+}
+
+// errBadRequest returns a bad_request error, which is returned with a 400 status.
+func errBadRequest(err error) error {
+	return &loginError{code: errCodeBadRequest, status: http.StatusBadRequest, err: err}
+}
+
+// errSessionInvalid returns a session_invalid error, which is returned with a 403 status.
+func errSessionInvalid(err error) error {
+	return &loginError{code: errCodeSessionInvalid, status: http.StatusForbidden, err: err}
+}
+
+// errRelationDenied returns a relation_denied error, which is returned with a 403 status.
+func errRelationDenied(err error) error {
+	return &loginError{code: errCodeRelationDenied, status: http.StatusForbidden, err: err}
+}
+
+// errUpstreamUnavailable returns an upstream_unavailable error, which is returned with a 503 status.
+func errUpstreamUnavailable(err error) error {
+	return &loginError{code: errCodeUpstreamUnavailable, status: http.StatusServiceUnavailable, err: err}
+}
+
+// errDenied returns an error with a specific code for a denied login, such as a Kratos error ID telling
+// the client which flow to send the user through, which is returned with a 403 status.
+func errDenied(code string, err error) error {
+	return &loginError{code: code, status: http.StatusForbidden, err: err}
+}
+
+// withErrorCode returns the error unchanged if it already has an error code, and otherwise gives it one.
+func withErrorCode(err error, classify func(error) error) error {
+	var loginErr *loginError
+	if errors.As(err, &loginErr) {
+		return err
+	}
+
+	return classify(err)
+}
+
+// loginErrorResponse converts a login failure to the response and error returned to Vault, which
+// determine the HTTP status. Errors without an error code are classified with classify, and the
+// message always starts with the code, even if the coded error was wrapped.
+//
+// Denied logins are returned with logical.ErrPermissionDenied (403), unavailable upstreams with a coded
+// 503 error, and anything else as an error response (400).
+func loginErrorResponse(err error, classify func(error) error) (*logical.Response, error) {
+	var loginErr *loginError
+	if !errors.As(withErrorCode(err, classify), &loginErr) {
+		return nil, err
+	}
+
+	message := loginErr.Error()
+
+	switch loginErr.status {
+	case http.StatusForbidden:
+		return logical.ErrorResponse(message), logical.ErrPermissionDenied
+	case http.StatusServiceUnavailable:
+		return nil, logical.CodedError(http.StatusServiceUnavailable, message)
+	default:
+		return logical.ErrorResponse(message), logical.ErrInvalidRequest
+	}
+}
@@ -0,0 +1,166 @@
+package plugin
+
+import (
+	"context"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/pkg/errors"
+)
+
+const (
+	// maxGroupDepth bounds the levels of nested groups resolved, counting the groups of the subject itself.
+	maxGroupDepth = 5
+
+	// maxGroupAliases bounds the number of groups a subject can be a member of.
+	maxGroupAliases = 100
+)
+
+// parseGroupRelation parses a group relation in the format `namespace#relation`, as a tuple without an object.
+func parseGroupRelation(raw string) (relationTuple, error) {
+	namespace, relation, ok := strings.Cut(raw, "#")
+	if !ok || namespace == "" || relation == "" || strings.Contains(namespace, ":") {
+		return relationTuple{}, errors.Errorf("%q is not in the format namespace#relation", raw)
+	}
+
+	return relationTuple{Namespace: namespace, Relation: relation}, nil
+}
+
+// validateGroupRelations checks that the group relations can be parsed.
+func validateGroupRelations(groupRelations []string) error {
+	for _, raw := range groupRelations {
+		_, err := parseGroupRelation(raw)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// getGroupAliases returns a group alias for every group the subject is a member of, named after the
+// group's subject set in Keto's `namespace:object#relation` notation (e.g. `groups:platform#member`).
+//
+// The groups of the subject are listed for each configured group relation, followed by the groups whose
+// subject sets include the groups found, down to maxGroupDepth levels of nesting.
+func (b *OryAuthBackend) getGroupAliases(
+	ctx context.Context,
+	req *logical.Request,
+	config *Config,
+	subject string,
+) ([]*logical.Alias, error) {
+	if len(config.GroupRelations) == 0 {
+		return nil, nil
+	}
+
+	groupRelations := make([]relationTuple, 0, len(config.GroupRelations))
+	for _, raw := range config.GroupRelations {
+		groupRelation, err := parseGroupRelation(raw)
+		if err != nil {
+			return nil, err
+		}
+
+		groupRelations = append(groupRelations, groupRelation)
+	}
+
+	ketoClient, err := b.getKetoClient(ctx, req.Storage)
+	if err != nil {
+		return nil, errUpstreamUnavailable(errors.Wrap(err, "failed to get keto client"))
+	}
+
+	// the aliases are never nil once groups are configured, as Vault only refreshes the external group
+	// memberships of an entity on renewal if they are set
+	seen := make(map[string]bool)
+	aliases := make([]*logical.Alias, 0)
+
+	members := []ketoSubject{{id: subject}}
+	for depth := 0; depth < maxGroupDepth && len(members) > 0; depth++ {
+		var groups []ketoSubject
+
+		for _, member := range members {
+			for _, groupRelation := range groupRelations {
+				objects, err := b.listGroups(ctx, ketoClient, groupRelation, member)
+				if err != nil {
+					return nil, withErrorCode(errors.Wrap(err, "failed to list groups"), errUpstreamUnavailable)
+				}
+
+				for _, object := range objects {
+					group := relationTuple{
+						Namespace: groupRelation.Namespace,
+						Object:    object,
+						Relation:  groupRelation.Relation,
+					}
+
+					name := group.String()
+					if seen[name] {
+						continue
+					}
+					seen[name] = true
+
+					if len(aliases) >= maxGroupAliases {
+						return nil, errDenied("too_many_groups", errors.Errorf(
+							"subject is a member of more than %d groups",
+							maxGroupAliases,
+						))
+					}
+
+					aliases = append(aliases, &logical.Alias{Name: name})
+					groups = append(groups, ketoSubject{set: &group})
+				}
+			}
+		}
+
+		members = groups
+	}
+
+	if len(members) > 0 {
+		b.Logger().Warn("not resolving groups nested deeper than the maximum depth", "max_depth", maxGroupDepth)
+	}
+
+	b.Logger().Debug("resolved group aliases", "count", len(aliases))
+
+	return aliases, nil
+}
+
+// listGroups returns the objects of every group of the group relation that the subject is a direct member
+// of, paging through the relation tuples.
+func (b *OryAuthBackend) listGroups(
+	ctx context.Context,
+	ketoClient *KetoClient,
+	groupRelation relationTuple,
+	subject ketoSubject,
+) ([]string, error) {
+	var objects []string
+	var pageToken string
+	for page := 0; page < maxListObjectsPages; page++ {
+		listCtx, cancel := context.WithTimeout(ctx, ketoClient.readTimeout)
+		pageObjects, nextPageToken, err := ketoClient.checker.listObjects(
+			listCtx,
+			groupRelation.Namespace,
+			groupRelation.Relation,
+			subject,
+			pageToken,
+			listObjectsPageSize,
+		)
+		cancel()
+
+		if err != nil {
+			return nil, err
+		}
+
+		objects = append(objects, pageObjects...)
+
+		if nextPageToken == "" {
+			return objects, nil
+		}
+
+		pageToken = nextPageToken
+	}
+
+	return nil, errDenied("too_many_groups", errors.Errorf(
+		"subject is a direct member of more than %d groups of %s#%s",
+		maxListObjectsPages*listObjectsPageSize,
+		groupRelation.Namespace,
+		groupRelation.Relation,
+	))
+}
@@ -0,0 +1,198 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/pkg/errors"
+)
+
+// hydraIntrospectionPath is the path of the token introspection endpoint on the Hydra admin API.
+const hydraIntrospectionPath = "/admin/oauth2/introspect"
+
+// HydraClient is a client for the Ory Hydra admin API.
+type HydraClient struct {
+	// httpClient is the HTTP client used to make requests to Hydra.
+	httpClient *http.Client
+
+	// introspectionURL is the full URL of the token introspection endpoint.
+	introspectionURL string
+
+	// clientID is the client ID used to authenticate introspection requests.
+	clientID string
+
+	// clientSecret is the client secret used to authenticate introspection requests.
+	clientSecret string
+}
+
+// HydraIntrospection is the result of introspecting an OAuth2 token with Hydra.
+type HydraIntrospection struct {
+	Active    bool   `json:"active"`
+	Subject   string `json:"sub,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	Scope     string `json:"scope,omitempty"`
+	ExpiresAt int64  `json:"exp,omitempty"`
+	TokenUse  string `json:"token_use,omitempty"`
+}
+
+// getHydraClient returns a client for the Ory Hydra admin API.
+func (b *OryAuthBackend) getHydraClient(
+	ctx context.Context,
+	s logical.Storage,
+) (*HydraClient, error) {
+	b.Logger().Debug("getting hydra client")
+
+	b.hydraClientMutex.Lock()
+	defer b.hydraClientMutex.Unlock()
+
+	if b.hydraClient != nil {
+		b.Logger().Debug("returning existing hydra client")
+
+		return b.hydraClient, nil
+	}
+
+	b.Logger().Debug("could not find existing hydra client, creating new one")
+
+	config, err := b.readConfig(ctx, s)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read config")
+	}
+
+	if config == nil || config.HydraAdminURL == "" {
+		return nil, errors.New("hydra_admin_url is not configured")
+	}
+
+	b.hydraClient = &HydraClient{
+		httpClient:       &http.Client{},
+		introspectionURL: strings.TrimSuffix(config.HydraAdminURL, "/") + hydraIntrospectionPath,
+		clientID:         config.HydraClientID,
+		clientSecret:     config.HydraClientSecret,
+	}
+
+	b.Logger().Debug("returning new hydra client", "url", b.hydraClient.introspectionURL)
+
+	return b.hydraClient, nil
+}
+
+// closeHydraClient closes the client for the Ory Hydra admin API.
+func (b *OryAuthBackend) closeHydraClient() {
+	b.Logger().Debug("closing hydra client")
+
+	b.hydraClientMutex.Lock()
+	defer b.hydraClientMutex.Unlock()
+
+	if b.hydraClient == nil {
+		return
+	}
+
+	b.hydraClient.httpClient.CloseIdleConnections()
+	b.hydraClient = nil
+
+	b.Logger().Debug("closed hydra client")
+}
+
+// introspectAccessToken introspects the access token by making a request to the Hydra admin API.
+func (b *OryAuthBackend) introspectAccessToken(
+	ctx context.Context,
+	client *HydraClient,
+	accessToken string,
+) (*HydraIntrospection, error) {
+	form := url.Values{}
+	form.Set("token", accessToken)
+
+	req, err := http.NewRequestWithContext(
+		ctx,
+		http.MethodPost,
+		client.introspectionURL,
+		strings.NewReader(form.Encode()),
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create introspection request")
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	if client.clientID != "" {
+		req.SetBasicAuth(client.clientID, client.clientSecret)
+	}
+
+	res, err := client.httpClient.Do(req)
+	if err != nil {
+		b.Logger().Error("error while trying to introspect access token", "err", err)
+		return nil, errUpstreamUnavailable(errors.Wrap(err, "failed to introspect access token"))
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		b.Logger().Debug("status was not 200", "status", res.StatusCode)
+		return nil, errUpstreamUnavailable(errors.Errorf("failed to introspect access token: status %d", res.StatusCode))
+	}
+
+	introspection := &HydraIntrospection{}
+	err = json.NewDecoder(res.Body).Decode(introspection)
+	if err != nil {
+		return nil, errUpstreamUnavailable(errors.Wrap(err, "failed to decode introspection response"))
+	}
+
+	return introspection, nil
+}
+
+// validateIntrospection checks that the introspected token is active and carries the required scopes.
+func (b *OryAuthBackend) validateIntrospection(
+	introspection *HydraIntrospection,
+	requiredScopes []string,
+) error {
+	if introspection == nil {
+		return errors.New("introspection is nil")
+	}
+
+	if !introspection.Active {
+		return errors.New("access token is not active")
+	}
+
+	if introspection.TokenUse != "" && introspection.TokenUse != "access_token" {
+		return errors.Errorf("token is a %s, expected an access_token", introspection.TokenUse)
+	}
+
+	if introspection.ExpiresAt != 0 && time.Unix(introspection.ExpiresAt, 0).Before(time.Now()) {
+		return errors.New("access token has expired")
+	}
+
+	granted := make(map[string]bool)
+	for _, scope := range strings.Fields(introspection.Scope) {
+		granted[scope] = true
+	}
+
+	for _, scope := range requiredScopes {
+		if !granted[scope] {
+			return errors.Errorf("access token is missing required scope %q", scope)
+		}
+	}
+
+	return nil
+}
+
+// getHydraSubject returns the Keto subject for the introspected access token.
+func (b *OryAuthBackend) getHydraSubject(introspection *HydraIntrospection) (string, error) {
+	b.Logger().Debug("getting subject from Hydra introspection")
+
+	if introspection == nil {
+		return "", errors.New("introspection is nil")
+	}
+
+	if introspection.Subject != "" {
+		return introspection.Subject, nil
+	}
+
+	if introspection.ClientID != "" {
+		return introspection.ClientID, nil
+	}
+
+	return "", errors.New("access token has neither a sub nor a client_id")
+}
@@ -0,0 +1,377 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/helper/strutil"
+	"github.com/hashicorp/vault/sdk/logical"
+	kratos "github.com/ory/kratos-client-go"
+	"github.com/pkg/errors"
+)
+
+const (
+	// aliasNameSourceIdentityID names aliases after the Kratos identity ID (or the subject for
+	// non-Kratos logins).
+	aliasNameSourceIdentityID = "identity_id"
+
+	// aliasNameSourceShared names every alias "ory-auth", collapsing every caller into one entity.
+	// This is the behaviour of earlier versions of the plugin and is kept for migrating mounts.
+	aliasNameSourceShared = "shared"
+
+	// aliasNameSourceCredentialsPrefix names aliases after the first identifier of a Kratos
+	// credential type (e.g. credentials.password), which Kratos keeps unique across identities.
+	aliasNameSourceCredentialsPrefix = "credentials."
+
+	// sharedAliasName is the alias name used by aliasNameSourceShared.
+	sharedAliasName = "ory-auth"
+
+	// traitsPrefix optionally prefixes the paths of Kratos identity traits (e.g. traits.email).
+	traitsPrefix = "traits."
+)
+
+// validateAliasNameSource checks that the alias name source is supported.
+func validateAliasNameSource(source string) error {
+	switch {
+	case source == "", source == aliasNameSourceIdentityID, source == aliasNameSourceShared:
+		return nil
+	case strings.HasPrefix(source, traitsPrefix):
+		// users can change their own traits, and so could take over the entity of another user
+		return errors.Errorf(
+			"alias_name_source %q is not supported, as users can edit their traits; use identity_id or credentials.<type>",
+			source,
+		)
+	case strings.HasPrefix(source, aliasNameSourceCredentialsPrefix) && len(source) > len(aliasNameSourceCredentialsPrefix):
+		return nil
+	default:
+		return errors.Errorf(
+			"invalid alias_name_source %q, expected identity_id, shared or credentials.<type>",
+			source,
+		)
+	}
+}
+
+// getAliasName returns the name of the entity alias for the principal.
+//
+// Credential sources only apply to Kratos sessions; other logins are named after their subject.
+func (b *OryAuthBackend) getAliasName(config *Config, principal *loginPrincipal) (string, error) {
+	source := config.AliasNameSource
+
+	switch {
+	case source == aliasNameSourceShared:
+		return sharedAliasName, nil
+	case principal.session == nil, source == "", source == aliasNameSourceIdentityID:
+		return principal.subject, nil
+	case strings.HasPrefix(source, aliasNameSourceCredentialsPrefix):
+		credentialType := strings.TrimPrefix(source, aliasNameSourceCredentialsPrefix)
+
+		if principal.session.Identity.Credentials != nil {
+			credentials, ok := (*principal.session.Identity.Credentials)[credentialType]
+			if ok && len(credentials.Identifiers) > 0 && credentials.Identifiers[0] != "" {
+				return credentials.Identifiers[0], nil
+			}
+		}
+
+		return "", errors.Errorf("identity has no %q credential identifier to use as the alias name", credentialType)
+	default:
+		return "", validateAliasNameSource(source)
+	}
+}
+
+// checkSchemaID checks that the identity of the principal's session has one of the allowed schema IDs.
+func (b *OryAuthBackend) checkSchemaID(config *Config, principal *loginPrincipal) error {
+	if len(config.AllowedSchemaIDs) == 0 {
+		return nil
+	}
+
+	if principal.session == nil {
+		return errDenied(
+			"identity_schema_not_allowed",
+			errors.Errorf("the identity schema of a %s login cannot be verified", principal.method),
+		)
+	}
+
+	schemaID := principal.session.Identity.SchemaId
+	if !strutil.StrListContains(config.AllowedSchemaIDs, schemaID) {
+		return errDenied("identity_schema_not_allowed", errors.Errorf("identity schema %q is not allowed to log in", schemaID))
+	}
+
+	return nil
+}
+
+// identityPoliciesPathRoots are the parts of a Kratos identity that policies can be read from. Traits are not
+// among them, as users can change their own traits through Kratos settings flows.
+var identityPoliciesPathRoots = []string{"metadata_admin", "metadata_public"}
+
+// validateIdentityPoliciesPath checks that the identity policies path points into the identity's metadata.
+func validateIdentityPoliciesPath(path string) error {
+	if path == "" {
+		return nil
+	}
+
+	root, rest, _ := strings.Cut(path, ".")
+	if !strutil.StrListContains(identityPoliciesPathRoots, root) || rest == "" {
+		return errors.Errorf(
+			"invalid identity_policies_path %q, expected metadata_admin.<path> or metadata_public.<path>",
+			path,
+		)
+	}
+
+	return nil
+}
+
+// getIdentityPolicies returns the policies found at the identity policies path of the principal's identity,
+// which is fetched from the Kratos admin API.
+//
+// Only policies matching the allowed identity policies are returned, so that identities cannot grant
+// themselves arbitrary policies; the others are logged and dropped.
+func (b *OryAuthBackend) getIdentityPolicies(
+	ctx context.Context,
+	s logical.Storage,
+	config *Config,
+	principal *loginPrincipal,
+) ([]string, error) {
+	if config.IdentityPoliciesPath == "" || principal.session == nil {
+		return nil, nil
+	}
+
+	identity, err := b.getAdminIdentity(ctx, s, config, principal.session.Identity.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	root, path, _ := strings.Cut(config.IdentityPoliciesPath, ".")
+	var obj interface{}
+	switch root {
+	case "metadata_admin":
+		obj = identity.MetadataAdmin
+	case "metadata_public":
+		obj = identity.MetadataPublic
+	default:
+		return nil, validateIdentityPoliciesPath(config.IdentityPoliciesPath)
+	}
+
+	val, ok := lookupPath(obj, path)
+	if !ok || val == nil {
+		b.Logger().Debug("identity has no policies", "path", config.IdentityPoliciesPath)
+		return nil, nil
+	}
+
+	var candidates []string
+	switch v := val.(type) {
+	case string:
+		candidates = strutil.ParseStringSlice(v, ",")
+	case []interface{}:
+		for _, item := range v {
+			policy, ok := item.(string)
+			if !ok {
+				return nil, errors.Errorf("identity policies at %q must be strings", config.IdentityPoliciesPath)
+			}
+
+			candidates = append(candidates, policy)
+		}
+	default:
+		return nil, errors.Errorf(
+			"identity policies at %q were a %T, expected a list or a comma-separated string",
+			config.IdentityPoliciesPath,
+			val,
+		)
+	}
+
+	policies := make([]string, 0, len(candidates))
+	for _, policy := range candidates {
+		policy = strings.TrimSpace(policy)
+		if policy == "" {
+			continue
+		}
+
+		if !strutil.StrListContainsGlob(config.AllowedIdentityPolicies, policy) {
+			b.Logger().Warn("dropping identity policy that is not allowed", "policy", policy)
+			continue
+		}
+
+		policies = append(policies, policy)
+	}
+
+	return strutil.RemoveDuplicatesStable(policies, false), nil
+}
+
+// reservedMetadataKeys are the alias metadata keys set by the plugin, which cannot be mapped from traits.
+var reservedMetadataKeys = []string{
+	"namespace",
+	"object",
+	"relation",
+	"subject",
+	"role",
+	"schema_id",
+	"tuple_count",
+	"objects",
+}
+
+// reservedMetadataKeyRegex matches the numbered alias metadata keys set by the plugin for each tuple of a login.
+var reservedMetadataKeyRegex = regexp.MustCompile(`^(namespace|object|relation)_[0-9]+$`)
+
+// isReservedMetadataKey reports whether the alias metadata key is set by the plugin.
+func isReservedMetadataKey(key string) bool {
+	return strutil.StrListContains(reservedMetadataKeys, key) || reservedMetadataKeyRegex.MatchString(key)
+}
+
+// validateTraitMetadata checks that the trait metadata mapping does not override metadata set by the plugin.
+func validateTraitMetadata(traitMetadata map[string]string) error {
+	for path, key := range traitMetadata {
+		root, rest, _ := strings.Cut(path, ".")
+		if strutil.StrListContains(identityPoliciesPathRoots, root) && rest == "" {
+			return errors.Errorf("metadata paths in trait_metadata must not be empty, got %q", path)
+		}
+
+		if strings.TrimPrefix(path, traitsPrefix) == "" {
+			return errors.New("trait paths in trait_metadata must not be empty")
+		}
+
+		if key == "" {
+			return errors.Errorf("metadata key for trait %q must not be empty", path)
+		}
+
+		if isReservedMetadataKey(key) {
+			return errors.Errorf("metadata key %q for trait %q is reserved", key, path)
+		}
+	}
+
+	return nil
+}
+
+// getTraitMetadata returns the alias metadata mapped from the Kratos identity traits and metadata of the principal.
+//
+// Traits are looked up by their dot-separated path, optionally prefixed with "traits.". Paths prefixed with
+// "metadata_public." or "metadata_admin." are looked up in the identity's metadata instead, which users cannot
+// edit; the admin metadata is fetched from the Kratos admin API only when it is mapped. Missing values are
+// skipped, and values are coerced to strings.
+func (b *OryAuthBackend) getTraitMetadata(
+	ctx context.Context,
+	s logical.Storage,
+	config *Config,
+	principal *loginPrincipal,
+) (map[string]string, error) {
+	metadata := make(map[string]string)
+
+	if principal.session == nil || len(config.TraitMetadata) == 0 {
+		return metadata, nil
+	}
+
+	var adminIdentity *kratos.Identity
+	for path, key := range config.TraitMetadata {
+		// configs stored before a key was reserved may still map a trait to it
+		if isReservedMetadataKey(key) {
+			b.Logger().Warn("not mapping identity trait to reserved metadata key", "trait", path, "key", key)
+			continue
+		}
+
+		obj := principal.session.Identity.Traits
+		lookup := strings.TrimPrefix(path, traitsPrefix)
+
+		root, rest, _ := strings.Cut(path, ".")
+		switch root {
+		case "metadata_public":
+			obj, lookup = principal.session.Identity.MetadataPublic, rest
+		case "metadata_admin":
+			if adminIdentity == nil {
+				var err error
+				adminIdentity, err = b.getAdminIdentity(ctx, s, config, principal.session.Identity.Id)
+				if err != nil {
+					return nil, err
+				}
+			}
+
+			obj, lookup = adminIdentity.MetadataAdmin, rest
+		}
+
+		val, ok := lookupPath(obj, lookup)
+		if !ok || val == nil {
+			b.Logger().Debug("identity has no trait to map to metadata", "trait", path)
+			continue
+		}
+
+		str, err := coerceToString(val)
+		if err != nil {
+			b.Logger().Warn("could not map identity trait to metadata", "trait", path, "err", err)
+			continue
+		}
+
+		metadata[key] = str
+	}
+
+	return metadata, nil
+}
+
+// coerceToString converts a decoded JSON value to a string for use in alias metadata.
+//
+// Scalars are formatted directly, lists of scalars are joined with commas, and objects are JSON encoded.
+func coerceToString(val interface{}) (string, error) {
+	if str, ok := stringify(val); ok {
+		return str, nil
+	}
+
+	if list, ok := val.([]interface{}); ok {
+		items := make([]string, 0, len(list))
+		for _, item := range list {
+			str, ok := stringify(item)
+			if !ok {
+				return jsonString(val)
+			}
+
+			items = append(items, str)
+		}
+
+		return strings.Join(items, ","), nil
+	}
+
+	return jsonString(val)
+}
+
+// jsonString encodes the value as a JSON string.
+func jsonString(val interface{}) (string, error) {
+	encoded, err := json.Marshal(val)
+	if err != nil {
+		return "", errors.Wrap(err, "could not encode value as JSON")
+	}
+
+	return string(encoded), nil
+}
+
+// lookupPath returns the value at the dot-separated path in a decoded JSON object.
+func lookupPath(obj interface{}, path string) (interface{}, bool) {
+	current := obj
+
+	for _, key := range strings.Split(path, ".") {
+		m, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+
+		current, ok = m[key]
+		if !ok {
+			return nil, false
+		}
+	}
+
+	return current, true
+}
+
+// stringify converts a decoded JSON scalar to a string.
+func stringify(val interface{}) (string, bool) {
+	switch v := val.(type) {
+	case string:
+		return v, true
+	case bool:
+		return strconv.FormatBool(v), true
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	case json.Number:
+		return v.String(), true
+	default:
+		return "", false
+	}
+}
@@ -0,0 +1,93 @@
+package plugin
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	kratos "github.com/ory/kratos-client-go"
+)
+
+func TestGetTraitMetadata(t *testing.T) {
+	principal := &loginPrincipal{
+		session: &kratos.Session{
+			Identity: kratos.Identity{
+				Id: "identity",
+				Traits: map[string]interface{}{
+					"email": "alice@example.com",
+					"name":  map[string]interface{}{"last": "Liddell"},
+				},
+				MetadataPublic: map[string]interface{}{
+					"org_id": "wonderland",
+					"teams":  []interface{}{"red", "white"},
+				},
+			},
+		},
+	}
+
+	config := &Config{
+		TraitMetadata: map[string]string{
+			"traits.email":           "email",
+			"name.last":              "last_name",
+			"metadata_public.org_id": "org",
+			"metadata_public.teams":  "teams",
+			"metadata_public.none":   "none",
+			"traits.org_id":          "trait_org",
+			"traits.objects":         "objects",
+		},
+	}
+
+	b, storage := newTestBackend(t, config)
+
+	got, err := b.getTraitMetadata(context.Background(), storage, config, principal)
+	if err != nil {
+		t.Fatalf("getTraitMetadata() error = %v", err)
+	}
+
+	want := map[string]string{
+		"email":     "alice@example.com",
+		"last_name": "Liddell",
+		"org":       "wonderland",
+		"teams":     "red,white",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("getTraitMetadata() = %v, want %v", got, want)
+	}
+}
+
+func TestValidateTraitMetadata(t *testing.T) {
+	tests := []struct {
+		name          string
+		traitMetadata map[string]string
+		wantErr       bool
+	}{
+		{
+			name:          "traits and metadata",
+			traitMetadata: map[string]string{"traits.email": "email", "metadata_admin.org_id": "org"},
+		},
+		{
+			name:          "empty trait path",
+			traitMetadata: map[string]string{"traits.": "email"},
+			wantErr:       true,
+		},
+		{
+			name:          "empty metadata path",
+			traitMetadata: map[string]string{"metadata_admin.": "org"},
+			wantErr:       true,
+		},
+		{
+			name:          "reserved key",
+			traitMetadata: map[string]string{"metadata_public.org_id": "object_0"},
+			wantErr:       true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateTraitMetadata(tt.traitMetadata)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateTraitMetadata() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,252 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/pkg/errors"
+	jose "gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+const (
+	// defaultJWTSubjectClaim is the claim used as the Keto subject if none is configured.
+	defaultJWTSubjectClaim = "sub"
+
+	// maxJWKSResponseBytes bounds the size of a JWKS fetched from jwt_jwks_url.
+	maxJWKSResponseBytes = 1 << 20
+
+	// minJWKSRefreshInterval bounds how often a JWT with an unknown key ID can re-fetch the JWKS.
+	minJWKSRefreshInterval = 30 * time.Second
+)
+
+// getJWKS returns the JSON Web Key Set used to verify login JWTs.
+func (b *OryAuthBackend) getJWKS(ctx context.Context, s logical.Storage) (*jose.JSONWebKeySet, error) {
+	b.Logger().Debug("getting jwks")
+
+	b.jwksMutex.RLock()
+	jwks := b.jwks
+	b.jwksMutex.RUnlock()
+
+	if jwks != nil {
+		b.Logger().Debug("returning existing jwks")
+
+		return jwks, nil
+	}
+
+	b.Logger().Debug("could not find existing jwks, loading it")
+
+	return b.refreshJWKS(ctx, s)
+}
+
+// refreshJWKS loads the JSON Web Key Set from the config, fetching it from jwt_jwks_url if set.
+func (b *OryAuthBackend) refreshJWKS(ctx context.Context, s logical.Storage) (*jose.JSONWebKeySet, error) {
+	config, err := b.readConfig(ctx, s)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read config")
+	}
+
+	if config == nil {
+		return nil, errors.New("plugin is not configured")
+	}
+
+	var raw []byte
+	switch {
+	case config.JWTJWKS != "":
+		raw = []byte(config.JWTJWKS)
+	case config.JWTJWKSURL != "":
+		raw, err = b.fetchJWKS(ctx, config.JWTJWKSURL)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errors.New("neither jwt_jwks nor jwt_jwks_url is configured")
+	}
+
+	jwks := &jose.JSONWebKeySet{}
+	err = json.Unmarshal(raw, jwks)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode jwks")
+	}
+
+	if len(jwks.Keys) == 0 {
+		return nil, errors.New("jwks contains no keys")
+	}
+
+	b.jwksMutex.Lock()
+	b.jwks = jwks
+	b.jwksRefreshedAt = time.Now()
+	b.jwksMutex.Unlock()
+
+	b.Logger().Debug("loaded jwks", "keys", len(jwks.Keys))
+
+	return jwks, nil
+}
+
+// fetchJWKS fetches the raw JSON Web Key Set from the given URL.
+func (b *OryAuthBackend) fetchJWKS(ctx context.Context, jwksURL string) ([]byte, error) {
+	b.Logger().Debug("fetching jwks", "url", jwksURL)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, jwksURL, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create jwks request")
+	}
+
+	req.Header.Set("Accept", "application/json")
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch jwks")
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("failed to fetch jwks: status %d", res.StatusCode)
+	}
+
+	raw, err := io.ReadAll(io.LimitReader(res.Body, maxJWKSResponseBytes))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read jwks response")
+	}
+
+	return raw, nil
+}
+
+// closeJWKS clears the cached JSON Web Key Set.
+func (b *OryAuthBackend) closeJWKS() {
+	b.jwksMutex.Lock()
+	defer b.jwksMutex.Unlock()
+
+	b.jwks = nil
+	b.jwksRefreshedAt = time.Time{}
+}
+
+// claimJWKSRefresh reports whether the JWKS may be re-fetched for a JWT with an unknown key ID. Logins are
+// unauthenticated, so this is allowed at most once every minJWKSRefreshInterval to stop made-up key IDs from
+// triggering a fetch on every login.
+func (b *OryAuthBackend) claimJWKSRefresh(now time.Time) bool {
+	b.jwksMutex.Lock()
+	defer b.jwksMutex.Unlock()
+
+	if !b.jwksRefreshedAt.IsZero() && now.Sub(b.jwksRefreshedAt) < minJWKSRefreshInterval {
+		return false
+	}
+
+	b.jwksRefreshedAt = now
+
+	return true
+}
+
+// validateJWT verifies the signature and standard claims of the JWT and returns its claims.
+func (b *OryAuthBackend) validateJWT(
+	ctx context.Context,
+	s logical.Storage,
+	config *Config,
+	rawToken string,
+) (*jwt.Claims, map[string]interface{}, error) {
+	token, err := jwt.ParseSigned(rawToken)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to parse jwt")
+	}
+
+	if len(token.Headers) == 0 {
+		return nil, nil, errors.New("jwt has no header")
+	}
+
+	// without an audience, a JWT minted by the same signer for any other service would be accepted
+	if len(config.JWTAudiences) == 0 {
+		return nil, nil, errors.New("jwt login requires jwt_audiences to be configured")
+	}
+
+	jwks, err := b.getJWKS(ctx, s)
+	if err != nil {
+		return nil, nil, errUpstreamUnavailable(errors.Wrap(err, "could not get jwks"))
+	}
+
+	keyID := token.Headers[0].KeyID
+	keys := jwks.Keys
+	if keyID != "" {
+		keys = jwks.Key(keyID)
+
+		// the signing keys may have been rotated since the jwks was last fetched
+		if len(keys) == 0 && config.JWTJWKSURL != "" && config.JWTJWKS == "" && b.claimJWKSRefresh(time.Now()) {
+			b.Logger().Debug("jwt key id not found, refreshing jwks", "kid", keyID)
+
+			jwks, err = b.refreshJWKS(ctx, s)
+			if err != nil {
+				return nil, nil, errUpstreamUnavailable(errors.Wrap(err, "could not refresh jwks"))
+			}
+
+			keys = jwks.Key(keyID)
+		}
+	}
+
+	if len(keys) == 0 {
+		return nil, nil, errors.Errorf("no key found to verify jwt with key id %q", keyID)
+	}
+
+	claims := &jwt.Claims{}
+	allClaims := make(map[string]interface{})
+
+	var verified bool
+	for _, key := range keys {
+		if err := token.Claims(key.Public(), claims, &allClaims); err == nil {
+			verified = true
+			break
+		}
+	}
+
+	if !verified {
+		return nil, nil, errors.New("failed to verify jwt signature")
+	}
+
+	if claims.Expiry == nil {
+		return nil, nil, errors.New("jwt has no exp claim")
+	}
+
+	err = claims.Validate(jwt.Expected{
+		Issuer: config.JWTIssuer,
+		Time:   time.Now(),
+	})
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "invalid jwt claims")
+	}
+
+	var audienceMatched bool
+	for _, audience := range config.JWTAudiences {
+		if claims.Audience.Contains(audience) {
+			audienceMatched = true
+			break
+		}
+	}
+
+	if !audienceMatched {
+		return nil, nil, errors.New("jwt audience does not match any of jwt_audiences")
+	}
+
+	return claims, allClaims, nil
+}
+
+// getJWTSubject returns the Keto subject from the JWT claims.
+func (b *OryAuthBackend) getJWTSubject(
+	config *Config,
+	claims map[string]interface{},
+) (string, error) {
+	b.Logger().Debug("getting subject from JWT claims")
+
+	subjectClaim := config.JWTSubjectClaim
+	if subjectClaim == "" {
+		subjectClaim = defaultJWTSubjectClaim
+	}
+
+	subject, ok := claims[subjectClaim].(string)
+	if !ok || subject == "" {
+		return "", errors.Errorf("jwt claim %q is missing or not a string", subjectClaim)
+	}
+
+	return subject, nil
+}
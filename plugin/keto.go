@@ -2,6 +2,9 @@ package plugin
 
 import (
 	"context"
+	"crypto/tls"
+	"net/http"
+	"time"
 
 	keto "github.com/ory/keto/proto/ory/keto/relation_tuples/v1alpha2"
 
@@ -9,10 +12,76 @@ import (
 
 	"github.com/pkg/errors"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
 )
 
+// defaultKetoTimeout is the deadline of each call to Keto if none is configured.
+const defaultKetoTimeout = 10 * time.Second
+
+// ketoConfig returns the Keto config, which is empty if none is stored.
+func (c *Config) ketoConfig() *KetoConfig {
+	if c.Keto == nil {
+		return &KetoConfig{}
+	}
+
+	return c.Keto
+}
+
+// migrateKetoHost moves the keto_host of configs stored before the keto config was nested to the
+// Keto read address.
+//
+// Those configs could only connect to Keto in plaintext, so they keep doing so.
+func (c *Config) migrateKetoHost() {
+	if c.KetoHost == "" {
+		return
+	}
+
+	if c.Keto == nil {
+		c.Keto = &KetoConfig{Plaintext: true}
+	}
+
+	if c.Keto.ReadAddr == "" {
+		c.Keto.ReadAddr = c.KetoHost
+	}
+
+	c.KetoHost = ""
+}
+
+// readTimeout returns the deadline of each call to the Keto read API.
+func (k *KetoConfig) readTimeout() time.Duration {
+	if k.ReadTimeoutSeconds > 0 {
+		return time.Duration(k.ReadTimeoutSeconds) * time.Second
+	}
+
+	return defaultKetoTimeout
+}
+
+// ketoTLSConfig returns the TLS config of the Keto gRPC connections.
+func ketoTLSConfig(config *KetoConfig) (*tls.Config, error) {
+	return newTLSConfig(tlsSettings{
+		caCert:     config.CACert,
+		clientCert: config.ClientCert,
+		clientKey:  config.ClientKey,
+		serverName: config.TLSServerName,
+	})
+}
+
+// validateKetoTransport checks that the Keto TLS settings are valid, and are not combined with plaintext.
+func validateKetoTransport(config *KetoConfig) error {
+	if config.Plaintext {
+		if config.CACert != "" || config.ClientCert != "" || config.ClientKey != "" || config.TLSServerName != "" {
+			return errors.New("keto_plaintext cannot be combined with keto TLS settings")
+		}
+
+		return nil
+	}
+
+	_, err := ketoTLSConfig(config)
+
+	return err
+}
+
 // getKetoClient returns a client for the Ory Keto API.
 func (b *OryAuthBackend) getKetoClient(
 	ctx context.Context,
@@ -20,8 +89,8 @@ func (b *OryAuthBackend) getKetoClient(
 ) (*KetoClient, error) {
 	b.Logger().Debug("getting keto client")
 
-	b.ketoClientMutex.RLock()
-	defer b.ketoClientMutex.RUnlock()
+	b.ketoClientMutex.Lock()
+	defer b.ketoClientMutex.Unlock()
 
 	if b.ketoClient != nil {
 		b.Logger().Debug("returning existing keto client")
@@ -36,26 +105,110 @@ func (b *OryAuthBackend) getKetoClient(
 		return nil, errors.Wrap(err, "could not read keto config")
 	}
 
-	b.Logger().Debug("creating keto client", "host", config.KetoHost)
+	if config == nil {
+		return nil, errors.New("plugin is not configured")
+	}
 
-	conn, err := grpc.Dial(
-		config.KetoHost,
-		grpc.WithTransportCredentials(insecure.NewCredentials()), // TODO support tls
-	)
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to connect to keto")
+	readTarget, readTLS := config.ketoReadTarget()
+	if readTarget == "" {
+		return nil, errors.New("keto_read_addr or an ory project is not configured")
+	}
+
+	ketoClient := &KetoClient{
+		readTimeout: config.ketoConfig().readTimeout(),
 	}
 
-	b.ketoClient = &KetoClient{
-		conn:               conn,
-		CheckServiceClient: keto.NewCheckServiceClient(conn),
+	switch config.ketoConfig().Transport {
+	case ketoTransportREST:
+		ketoClient.checker, err = b.newRESTRelationChecker(config, readTarget, readTLS)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		conn, err := b.dialKeto(config, readTarget, readTLS)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to connect to the keto read api")
+		}
+
+		ketoClient.conn = conn
+		ketoClient.checker = &grpcRelationChecker{
+			conn:   conn,
+			client: keto.NewCheckServiceClient(conn),
+			reader: keto.NewReadServiceClient(conn),
+		}
 	}
 
+	b.ketoClient = ketoClient
+
 	b.Logger().Debug("returning new keto client")
 
 	return b.ketoClient, nil
 }
 
+// dialKeto creates a gRPC connection to a Keto API.
+func (b *OryAuthBackend) dialKeto(config *Config, target string, useTLS bool) (*grpc.ClientConn, error) {
+	b.Logger().Debug("connecting to keto", "host", target, "tls", useTLS)
+
+	var opts []grpc.DialOption
+	if useTLS {
+		tlsConfig, err := ketoTLSConfig(config.ketoConfig())
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid keto TLS config")
+		}
+
+		opts = append(opts, grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)))
+	} else {
+		b.Logger().Warn("connecting to keto without TLS, authorization checks are sent in plaintext", "host", target)
+
+		opts = append(opts, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	}
+
+	if config.OryAPIKey != "" {
+		if !useTLS {
+			return nil, errors.New("ory_api_key can only be sent to keto over TLS")
+		}
+
+		opts = append(opts, grpc.WithPerRPCCredentials(ketoAPIKeyCredentials{apiKey: config.OryAPIKey}))
+	}
+
+	return grpc.Dial(target, opts...)
+}
+
+// newRESTRelationChecker creates a relation checker using the REST API served on the Keto read address.
+func (b *OryAuthBackend) newRESTRelationChecker(
+	config *Config,
+	target string,
+	useTLS bool,
+) (*restRelationChecker, error) {
+	b.Logger().Debug("using the keto rest api", "host", target, "tls", useTLS)
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	scheme := "https"
+	if useTLS {
+		tlsConfig, err := ketoTLSConfig(config.ketoConfig())
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid keto TLS config")
+		}
+
+		transport.TLSClientConfig = tlsConfig
+	} else {
+		b.Logger().Warn("connecting to keto without TLS, authorization checks are sent in plaintext", "host", target)
+
+		scheme = "http"
+	}
+
+	if config.OryAPIKey != "" && !useTLS {
+		return nil, errors.New("ory_api_key can only be sent to keto over TLS")
+	}
+
+	return &restRelationChecker{
+		baseURL:    scheme + "://" + target,
+		httpClient: &http.Client{Transport: transport},
+		apiKey:     config.OryAPIKey,
+	}, nil
+}
+
 // closeKetoClient closes the client to the Ory Keto API.
 func (b *OryAuthBackend) closeKetoClient() {
 	b.ketoClientMutex.Lock()
@@ -69,10 +222,6 @@ func (b *OryAuthBackend) closeKetoClient() {
 		b.ketoClient.conn.Close()
 	}
 
-	if b.ketoClient.CheckServiceClient != nil {
-		b.ketoClient.CheckServiceClient = nil
-	}
-
 	b.ketoClient = nil
 }
 
@@ -85,9 +234,12 @@ func (b *OryAuthBackend) checkKetoHealth(ctx context.Context, s logical.Storage)
 		return errors.Wrap(err, "failed to get keto client during health check")
 	}
 
-	connState := ketoClient.conn.GetState()
-	if connState != connectivity.Ready && connState != connectivity.Idle {
-		return errors.Errorf("keto health check failed: %v", connState)
+	ctx, cancel := context.WithTimeout(ctx, ketoClient.readTimeout)
+	defer cancel()
+
+	err = ketoClient.checker.healthy(ctx)
+	if err != nil {
+		return errors.Wrap(err, "keto health check failed")
 	}
 
 	b.Logger().Debug("keto health check passed")
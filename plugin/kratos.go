@@ -2,13 +2,72 @@ package plugin
 
 import (
 	"context"
+	"math/rand"
 	"net/http"
+	"time"
 
 	"github.com/hashicorp/vault/sdk/logical"
 	kratos "github.com/ory/kratos-client-go"
 	"github.com/pkg/errors"
 )
 
+const (
+	// defaultKratosTimeout is the timeout of each request to Kratos if none is configured.
+	defaultKratosTimeout = 10 * time.Second
+
+	// defaultKratosMaxRetries is the number of times a failed idempotent request to Kratos is retried
+	// if no number of retries is configured.
+	defaultKratosMaxRetries = 2
+
+	// defaultKratosCircuitBreakerThreshold is the number of consecutive failed requests to Kratos that
+	// opens the circuit breaker if no threshold is configured.
+	defaultKratosCircuitBreakerThreshold = 5
+
+	// defaultKratosCircuitBreakerCooldown is how long the circuit breaker stays open if no cooldown is configured.
+	defaultKratosCircuitBreakerCooldown = 30 * time.Second
+
+	// kratosRetryBaseBackoff and kratosRetryMaxBackoff bound the jittered exponential backoff between retries.
+	kratosRetryBaseBackoff = 100 * time.Millisecond
+	kratosRetryMaxBackoff  = 2 * time.Second
+)
+
+// kratosTimeout returns the timeout of each request to Kratos.
+func (c *Config) kratosTimeout() time.Duration {
+	if c.KratosTimeoutSeconds > 0 {
+		return time.Duration(c.KratosTimeoutSeconds) * time.Second
+	}
+
+	return defaultKratosTimeout
+}
+
+// kratosMaxRetries returns the number of times a failed idempotent request to Kratos is retried.
+func (c *Config) kratosMaxRetries() int {
+	if c.KratosMaxRetries != nil {
+		return *c.KratosMaxRetries
+	}
+
+	return defaultKratosMaxRetries
+}
+
+// kratosCircuitBreakerThreshold returns the number of consecutive failed requests to Kratos that opens the
+// circuit breaker, where 0 disables it.
+func (c *Config) kratosCircuitBreakerThreshold() int {
+	if c.KratosCircuitBreakerThreshold != nil {
+		return *c.KratosCircuitBreakerThreshold
+	}
+
+	return defaultKratosCircuitBreakerThreshold
+}
+
+// kratosCircuitBreakerCooldown returns how long the Kratos circuit breaker stays open.
+func (c *Config) kratosCircuitBreakerCooldown() time.Duration {
+	if c.KratosCircuitBreakerCooldownSeconds > 0 {
+		return time.Duration(c.KratosCircuitBreakerCooldownSeconds) * time.Second
+	}
+
+	return defaultKratosCircuitBreakerCooldown
+}
+
 // getKratosClient returns a client for the Ory Kratos API.
 func (b *OryAuthBackend) getKratosClient(
 	ctx context.Context,
@@ -16,8 +75,8 @@ func (b *OryAuthBackend) getKratosClient(
 ) (*kratos.APIClient, error) {
 	b.Logger().Debug("getting kratos client")
 
-	b.kratosClientMutex.RLock()
-	defer b.kratosClientMutex.RUnlock()
+	b.kratosClientMutex.Lock()
+	defer b.kratosClientMutex.Unlock()
 
 	if b.kratosClient != nil {
 		b.Logger().Debug("returning existing kratos client")
@@ -32,7 +91,10 @@ func (b *OryAuthBackend) getKratosClient(
 		return nil, errors.Wrap(err, "failed to read config")
 	}
 
-	kratosConfig := b.configToKratosConfig(config)
+	kratosConfig, err := b.configToKratosConfig(config)
+	if err != nil {
+		return nil, err
+	}
 
 	b.Logger().Debug("creating kratos client")
 	b.kratosClient = kratos.NewAPIClient(kratosConfig)
@@ -46,6 +108,8 @@ func (b *OryAuthBackend) getKratosClient(
 func (b *OryAuthBackend) closeKratosClient() {
 	b.Logger().Debug("closing kratos client")
 
+	b.closeSessionValidator()
+
 	b.kratosClientMutex.Lock()
 	defer b.kratosClientMutex.Unlock()
 
@@ -58,6 +122,197 @@ func (b *OryAuthBackend) closeKratosClient() {
 	b.Logger().Debug("closed kratos client")
 }
 
+// getKratosBreaker returns the circuit breaker guarding requests to Kratos.
+func (b *OryAuthBackend) getKratosBreaker(config *Config) *circuitBreaker {
+	b.kratosBreakerMutex.Lock()
+	defer b.kratosBreakerMutex.Unlock()
+
+	if b.kratosBreaker == nil {
+		b.kratosBreaker = newCircuitBreaker(
+			"kratos",
+			b.Logger(),
+			config.kratosCircuitBreakerThreshold(),
+			config.kratosCircuitBreakerCooldown(),
+		)
+	}
+
+	return b.kratosBreaker
+}
+
+// closeKratosBreaker discards the circuit breaker guarding requests to Kratos, so that it is
+// recreated with the current config.
+func (b *OryAuthBackend) closeKratosBreaker() {
+	b.kratosBreakerMutex.Lock()
+	defer b.kratosBreakerMutex.Unlock()
+
+	b.kratosBreaker = nil
+}
+
+// callKratos makes an idempotent request to Kratos with the configured timeout, retrying it with a
+// jittered exponential backoff while Kratos is unavailable.
+//
+// While the circuit breaker is open the request is not made and errCircuitOpen is returned.
+func (b *OryAuthBackend) callKratos(
+	ctx context.Context,
+	config *Config,
+	name string,
+	call func(ctx context.Context) (*http.Response, error),
+) (*http.Response, error) {
+	breaker := b.getKratosBreaker(config)
+
+	err := breaker.allow()
+	if err != nil {
+		b.Logger().Warn("not calling kratos while the circuit breaker is open", "request", name)
+		return nil, err
+	}
+
+	var res *http.Response
+	for attempt := 0; ; attempt++ {
+		attemptCtx, cancel := context.WithTimeout(ctx, config.kratosTimeout())
+		res, err = call(attemptCtx)
+		cancel()
+
+		if !isKratosUnavailable(res, err) {
+			breaker.record(true)
+			return res, err
+		}
+
+		if ctx.Err() != nil {
+			// the caller gave up, which says nothing about the health of Kratos
+			breaker.abandon()
+			return res, err
+		}
+
+		if attempt >= config.kratosMaxRetries() {
+			break
+		}
+
+		backoff := kratosRetryBaseBackoff << attempt
+		if backoff > kratosRetryMaxBackoff {
+			backoff = kratosRetryMaxBackoff
+		}
+		backoff = time.Duration(rand.Int63n(int64(backoff)) + 1)
+
+		b.Logger().Debug("retrying kratos request", "request", name, "attempt", attempt+1, "backoff", backoff, "err", err)
+
+		select {
+		case <-ctx.Done():
+			breaker.abandon()
+			return res, err
+		case <-time.After(backoff):
+		}
+	}
+
+	breaker.record(false)
+
+	return res, err
+}
+
+// isKratosUnavailable reports whether a request to Kratos failed because Kratos is unavailable, as opposed
+// to Kratos rejecting the request.
+func isKratosUnavailable(res *http.Response, err error) bool {
+	if res == nil {
+		return err != nil
+	}
+
+	return res.StatusCode >= http.StatusInternalServerError || res.StatusCode == http.StatusTooManyRequests
+}
+
+// getKratosAdminClient returns a client for the Ory Kratos admin API.
+func (b *OryAuthBackend) getKratosAdminClient(
+	ctx context.Context,
+	s logical.Storage,
+) (*kratos.APIClient, error) {
+	b.Logger().Debug("getting kratos admin client")
+
+	b.kratosAdminClientMutex.Lock()
+	defer b.kratosAdminClientMutex.Unlock()
+
+	if b.kratosAdminClient != nil {
+		b.Logger().Debug("returning existing kratos admin client")
+
+		return b.kratosAdminClient, nil
+	}
+
+	b.Logger().Debug("could not find existing kratos admin client, creating new one")
+
+	config, err := b.readConfig(ctx, s)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read config")
+	}
+
+	if config == nil || config.kratosAdminURL() == "" {
+		return nil, errors.New("kratos_admin_url or an ory project is not configured")
+	}
+
+	kratosConfig, err := b.configToKratosAdminConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	b.Logger().Debug("creating kratos admin client")
+	b.kratosAdminClient = kratos.NewAPIClient(kratosConfig)
+
+	b.Logger().Debug("returning new kratos admin client", "url", kratosConfig.Servers[0].URL)
+
+	return b.kratosAdminClient, nil
+}
+
+// closeKratosAdminClient closes the client for the Ory Kratos admin API.
+func (b *OryAuthBackend) closeKratosAdminClient() {
+	b.Logger().Debug("closing kratos admin client")
+
+	b.kratosAdminClientMutex.Lock()
+	defer b.kratosAdminClientMutex.Unlock()
+
+	if b.kratosAdminClient == nil {
+		return
+	}
+
+	b.kratosAdminClient = nil
+
+	b.Logger().Debug("closed kratos admin client")
+}
+
+// getAdminIdentity fetches the full identity, including its admin metadata, from the Kratos admin API.
+func (b *OryAuthBackend) getAdminIdentity(
+	ctx context.Context,
+	s logical.Storage,
+	config *Config,
+	id string,
+) (*kratos.Identity, error) {
+	b.Logger().Debug("getting identity from kratos admin api", "id", id)
+
+	kratosAdminClient, err := b.getKratosAdminClient(ctx, s)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get kratos admin client")
+	}
+
+	if apiKey := config.kratosAdminAPIKey(); apiKey != "" {
+		ctx = context.WithValue(ctx, kratos.ContextAPIKeys, map[string]kratos.APIKey{
+			"oryAccessToken": {Key: apiKey, Prefix: "Bearer"},
+		})
+	}
+
+	var identity *kratos.Identity
+	res, err := b.callKratos(ctx, config, "adminGetIdentity", func(ctx context.Context) (*http.Response, error) {
+		var res *http.Response
+		var err error
+		identity, res, err = kratosAdminClient.V0alpha2Api.AdminGetIdentity(ctx, id).Execute()
+
+		return res, err
+	})
+	if err != nil {
+		if res != nil {
+			return nil, errors.Wrapf(err, "failed to get identity: %v", res.StatusCode)
+		}
+
+		return nil, errors.Wrap(err, "failed to get identity")
+	}
+
+	return identity, nil
+}
+
 // checkKratosHealth checks the health of the Ory Kratos API.
 func (b *OryAuthBackend) checkKratosHealth(ctx context.Context, s logical.Storage) error {
 	b.Logger().Debug("checking kratos health")
@@ -67,9 +322,19 @@ func (b *OryAuthBackend) checkKratosHealth(ctx context.Context, s logical.Storag
 		return errors.Wrap(err, "failed to get kratos client during health check")
 	}
 
-	_, res, err := kratosClient.MetadataApi.IsAliveExecute(
-		kratos.MetadataApiApiIsAliveRequest{},
-	)
+	config, err := b.readConfig(ctx, s)
+	if err != nil {
+		return errors.Wrap(err, "failed to read config during health check")
+	}
+
+	if config == nil {
+		return errors.New("plugin is not configured")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, config.kratosTimeout())
+	defer cancel()
+
+	_, res, err := kratosClient.MetadataApi.IsAlive(ctx).Execute()
 	if err != nil {
 		return errors.Wrap(err, "kratos health check failed")
 	}
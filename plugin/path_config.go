@@ -6,6 +6,8 @@ import (
 	"fmt"
 
 	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/parseutil"
+	"github.com/hashicorp/vault/sdk/helper/strutil"
 	"github.com/hashicorp/vault/sdk/logical"
 	"github.com/pkg/errors"
 )
@@ -18,6 +20,22 @@ const (
 	configDescription = `This endpoint configures the details for accessing Ory APIs.`
 )
 
+// sensitiveConfigFields are the config fields that are stored but never returned when reading the config.
+var sensitiveConfigFields = []string{
+	"hydra_client_secret",
+	"kratos_admin_api_key",
+	"ory_api_key",
+	"kratos_client_key",
+}
+
+// sensitiveKetoConfigFields are the fields of the nested keto config that are stored but never returned
+// when reading the config.
+var sensitiveKetoConfigFields = []string{
+	"ca_cert",
+	"client_cert",
+	"client_key",
+}
+
 var configFields map[string]*framework.FieldSchema = map[string]*framework.FieldSchema{
 	// plugin
 	"ttl_seconds": {
@@ -50,17 +68,299 @@ var configFields map[string]*framework.FieldSchema = map[string]*framework.Field
 			Sensitive: false,
 		},
 	},
+	"require_role": {
+		Type:        framework.TypeBool,
+		Description: "Requires a role to be given at login, rejecting logins without one",
+		Required:    false,
+		Default:     false,
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name:      "Require Role",
+			Sensitive: false,
+		},
+	},
+	"allow_partial_tuples": {
+		Type:        framework.TypeBool,
+		Description: "Issues a token for the allowed tuples of a multi-tuple login, rather than failing if any tuple is denied",
+		Required:    false,
+		Default:     false,
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name:      "Allow Partial Tuples",
+			Sensitive: false,
+		},
+	},
+	"max_listed_objects": {
+		Type:        framework.TypeInt,
+		Description: "The maximum number of objects granted by a login without an object, or 0 to disable such logins",
+		Required:    false,
+		Default:     0,
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name:      "Max Listed Objects",
+			Sensitive: false,
+		},
+	},
+	"required_aal": {
+		Type:        framework.TypeString,
+		Description: "The minimum Kratos authenticator assurance level (aal1, aal2 or aal3) required to log in",
+		Required:    false,
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name:      "Required AAL",
+			Sensitive: false,
+		},
+	},
+	"namespace_required_aal": {
+		Type:        framework.TypeKVPairs,
+		Description: "Maps Keto namespaces to the minimum Kratos authenticator assurance level required to log in to them",
+		Required:    false,
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name:      "Namespace Required AAL",
+			Sensitive: false,
+		},
+	},
+	"max_session_age": {
+		Type:        framework.TypeDurationSecond,
+		Description: "The maximum time since a Kratos session was authenticated for it to be allowed to log in",
+		Required:    false,
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name:      "Max Session Age",
+			Sensitive: false,
+		},
+	},
+	"session_clock_skew": {
+		Type:        framework.TypeDurationSecond,
+		Description: "The time by which the clocks of Vault and Kratos may disagree when checking the expiry of a Kratos session",
+		Required:    false,
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name:      "Session Clock Skew",
+			Sensitive: false,
+		},
+	},
+	"namespace_max_session_age": {
+		Type:        framework.TypeKVPairs,
+		Description: "Maps Keto namespaces to the maximum time since a Kratos session was authenticated for it to be allowed to log in to them",
+		Required:    false,
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name:      "Namespace Max Session Age",
+			Sensitive: false,
+		},
+	},
+	"require_active_identity": {
+		Type:        framework.TypeBool,
+		Description: "Requires the Kratos identity to be in the active state to log in",
+		Required:    false,
+		Default:     false,
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name:      "Require Active Identity",
+			Sensitive: false,
+		},
+	},
+	"require_verified_address": {
+		Type:        framework.TypeBool,
+		Description: "Requires the Kratos identity to have at least one verified address to log in",
+		Required:    false,
+		Default:     false,
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name:      "Require Verified Address",
+			Sensitive: false,
+		},
+	},
+	"required_verified_address_via": {
+		Type:        framework.TypeString,
+		Description: "Requires the Kratos identity to have a verified address of this type (e.g. email) to log in",
+		Required:    false,
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name:      "Required Verified Address Via",
+			Sensitive: false,
+		},
+	},
+	"allowed_schema_ids": {
+		Type:        framework.TypeCommaStringSlice,
+		Description: "Kratos identity schema IDs that are allowed to log in (all schemas if empty)",
+		Required:    false,
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name:      "Allowed Schema IDs",
+			Sensitive: false,
+		},
+	},
+	"group_relations": {
+		Type:        framework.TypeCommaStringSlice,
+		Description: "Keto relations in the format namespace#relation (e.g. groups#member) whose subject sets are groups that logins are given group aliases for",
+		Required:    false,
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name:      "Group Relations",
+			Sensitive: false,
+		},
+	},
+	"alias_name_source": {
+		Type:        framework.TypeString,
+		Description: "What the entity alias is named after: identity_id, credentials.<type>, or shared to name every alias 'ory-auth'",
+		Required:    false,
+		Default:     aliasNameSourceIdentityID,
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name:      "Alias Name Source",
+			Sensitive: false,
+		},
+	},
+	"trait_metadata": {
+		Type:        framework.TypeKVPairs,
+		Description: "Maps Kratos identity trait or metadata paths (e.g. metadata_admin.org_id) to alias metadata keys; users can edit their traits, so map metadata for anything policies rely on",
+		Required:    false,
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name:      "Trait Metadata",
+			Sensitive: false,
+		},
+	},
+	"identity_policies_path": {
+		Type:        framework.TypeString,
+		Description: "The path of a list of policies in the Kratos identity (e.g. metadata_admin.vault_policies), fetched from the admin API at login",
+		Required:    false,
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name:      "Identity Policies Path",
+			Sensitive: false,
+		},
+	},
+	"allowed_identity_policies": {
+		Type:        framework.TypeCommaStringSlice,
+		Description: "Policies that may be granted from the identity policies path (globs allowed, e.g. kratos-*)",
+		Required:    false,
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name:      "Allowed Identity Policies",
+			Sensitive: false,
+		},
+	},
+	"relation_hierarchy": {
+		Type:        framework.TypeMap,
+		Description: "Maps a namespace to its relations, ordered from highest to lowest, used to select the highest relation when none is given at login",
+		Required:    false,
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name:      "Relation Hierarchy",
+			Sensitive: false,
+		},
+	},
+
+	// ory network
+	"ory_project_slug": {
+		Type:        framework.TypeString,
+		Description: "The slug of the Ory Network project, used for the Kratos and Keto URLs and the session cookie name",
+		Required:    false,
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name:      "Ory Project Slug",
+			Sensitive: false,
+		},
+	},
+	"ory_project_url": {
+		Type:        framework.TypeString,
+		Description: "The URL of the Ory Network project (e.g. a custom domain), overriding the URL derived from the slug",
+		Required:    false,
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name:      "Ory Project URL",
+			Sensitive: false,
+		},
+	},
+	"ory_api_key": {
+		Type:        framework.TypeString,
+		Description: "The Ory Network API key used for Kratos admin requests and sent as a bearer token to Keto",
+		Required:    false,
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name:      "Ory API Key",
+			Sensitive: true,
+		},
+	},
 
 	// keto
 	"keto_host": {
 		Type:        framework.TypeString,
-		Description: "The host of the Keto instance",
-		Required:    true,
+		Description: "Deprecated: use keto_read_addr",
+		Required:    false,
+		Deprecated:  true,
 		DisplayAttrs: &framework.DisplayAttributes{
 			Name:      "Keto host",
 			Sensitive: false,
 		},
 	},
+	"keto_read_addr": {
+		Type:        framework.TypeString,
+		Description: "The gRPC address of the Keto read API (defaults to the Ory Network project)",
+		Required:    false,
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name:      "Keto Read Address",
+			Sensitive: false,
+		},
+	},
+	"keto_write_addr": {
+		Type:        framework.TypeString,
+		Description: "The gRPC address of the Keto write API, stored for clients of the config but not dialled by the plugin",
+		Required:    false,
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name:      "Keto Write Address",
+			Sensitive: false,
+		},
+	},
+	"keto_transport": {
+		Type:        framework.TypeString,
+		Description: "The transport used to check relation tuples with Keto (grpc or rest)",
+		Required:    false,
+		Default:     "grpc",
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name:      "Keto Transport",
+			Sensitive: false,
+		},
+	},
+	"keto_read_timeout": {
+		Type:        framework.TypeDurationSecond,
+		Description: "The deadline of each call to the Keto read API",
+		Required:    false,
+		Default:     10,
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name:      "Keto Read Timeout",
+			Sensitive: false,
+		},
+	},
+	"keto_ca_cert": {
+		Type:        framework.TypeString,
+		Description: "A PEM bundle of CA certificates trusted for Keto, in addition to the system roots",
+		Required:    false,
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name:      "Keto CA Certificate",
+			Sensitive: true,
+		},
+	},
+	"keto_client_cert": {
+		Type:        framework.TypeString,
+		Description: "A PEM client certificate presented to Keto for mutual TLS",
+		Required:    false,
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name:      "Keto Client Certificate",
+			Sensitive: true,
+		},
+	},
+	"keto_client_key": {
+		Type:        framework.TypeString,
+		Description: "The PEM private key of the client certificate presented to Keto",
+		Required:    false,
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name:      "Keto Client Key",
+			Sensitive: true,
+		},
+	},
+	"keto_tls_server_name": {
+		Type:        framework.TypeString,
+		Description: "The name the Keto server certificate is verified against, if not the host of its address",
+		Required:    false,
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name:      "Keto TLS Server Name",
+			Sensitive: false,
+		},
+	},
+	"keto_plaintext": {
+		Type:        framework.TypeBool,
+		Description: "Connects to the Keto addresses without TLS. Only use this in development",
+		Required:    false,
+		Default:     false,
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name:      "Keto Plaintext",
+			Sensitive: false,
+		},
+	},
 
 	// kratos
 	"kratos_url": {
@@ -108,167 +408,744 @@ var configFields map[string]*framework.FieldSchema = map[string]*framework.Field
 			Sensitive: false,
 		},
 	},
-}
-
-// NewPathConfig creates a new path for configuring the backend.
-func NewPathConfig(b *OryAuthBackend) []*framework.Path {
-	return []*framework.Path{
-		{
-			Pattern: "config",
-			Fields:  configFields,
-			Callbacks: map[logical.Operation]framework.OperationFunc{
-				logical.CreateOperation: b.createConfigHandler,
-				logical.ReadOperation:   b.readConfigHandler,
-				logical.UpdateOperation: b.updateConfigHandler,
-				logical.DeleteOperation: b.deleteConfigHandler,
-			},
-			HelpSynopsis:    configSynopsis,
-			HelpDescription: configDescription,
+	"kratos_api_version": {
+		Type:        framework.TypeString,
+		Description: "The generation of the Kratos API used to validate sessions: v0alpha2, v1, or auto to detect it from the Kratos version",
+		Required:    false,
+		Default:     "auto",
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name:      "Kratos API Version",
+			Sensitive: false,
+		},
+	},
+	"kratos_timeout": {
+		Type:        framework.TypeDurationSecond,
+		Description: "The timeout of each request to Kratos",
+		Required:    false,
+		Default:     10,
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name:      "Kratos Timeout",
+			Sensitive: false,
+		},
+	},
+	"kratos_max_retries": {
+		Type:        framework.TypeInt,
+		Description: "The number of times a failed idempotent request to Kratos is retried while Kratos is unavailable",
+		Required:    false,
+		Default:     defaultKratosMaxRetries,
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name:      "Kratos Max Retries",
+			Sensitive: false,
+		},
+	},
+	"kratos_circuit_breaker_threshold": {
+		Type:        framework.TypeInt,
+		Description: "The number of consecutive failed requests to Kratos after which requests fail fast, or 0 to disable the circuit breaker",
+		Required:    false,
+		Default:     defaultKratosCircuitBreakerThreshold,
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name:      "Kratos Circuit Breaker Threshold",
+			Sensitive: false,
+		},
+	},
+	"kratos_circuit_breaker_cooldown": {
+		Type:        framework.TypeDurationSecond,
+		Description: "How long requests to Kratos fail fast before a trial request is let through",
+		Required:    false,
+		Default:     30,
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name:      "Kratos Circuit Breaker Cooldown",
+			Sensitive: false,
+		},
+	},
+	"kratos_ca_cert": {
+		Type:        framework.TypeString,
+		Description: "A PEM bundle of CA certificates trusted for Kratos, in addition to the system roots",
+		Required:    false,
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name:      "Kratos CA Certificate",
+			Sensitive: false,
+		},
+	},
+	"kratos_client_cert": {
+		Type:        framework.TypeString,
+		Description: "The PEM client certificate presented to Kratos for mutual TLS",
+		Required:    false,
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name:      "Kratos Client Certificate",
+			Sensitive: false,
+		},
+	},
+	"kratos_client_key": {
+		Type:        framework.TypeString,
+		Description: "The PEM private key of the client certificate presented to Kratos",
+		Required:    false,
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name:      "Kratos Client Key",
+			Sensitive: true,
+		},
+	},
+	"kratos_tls_server_name": {
+		Type:        framework.TypeString,
+		Description: "The name the Kratos server certificate is verified against, if not the host of the URL",
+		Required:    false,
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name:      "Kratos TLS Server Name",
+			Sensitive: false,
 		},
+	},
+	"kratos_tls_min_version": {
+		Type:        framework.TypeString,
+		Description: "The minimum TLS version used to connect to Kratos (tls10, tls11, tls12 or tls13)",
+		Required:    false,
+		Default:     "tls12",
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name:      "Kratos TLS Min Version",
+			Sensitive: false,
+		},
+	},
+	"kratos_tls_skip_verify": {
+		Type:        framework.TypeBool,
+		Description: "Disables the verification of the Kratos server certificate. Only use this in development",
+		Required:    false,
+		Default:     false,
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name:      "Kratos TLS Skip Verify",
+			Sensitive: false,
+		},
+	},
+	"kratos_admin_url": {
+		Type:        framework.TypeString,
+		Description: "The URL of the Kratos admin API, used to fetch full identities",
+		Required:    false,
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name:      "Kratos Admin URL",
+			Sensitive: false,
+		},
+	},
+	"kratos_admin_api_key": {
+		Type:        framework.TypeString,
+		Description: "The API key sent as a bearer token to the Kratos admin API",
+		Required:    false,
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name:      "Kratos Admin API Key",
+			Sensitive: true,
+		},
+	},
+
+	// hydra
+	"hydra_admin_url": {
+		Type:        framework.TypeString,
+		Description: "The admin URL of the Hydra instance used to introspect access tokens",
+		Required:    false,
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name:      "Hydra Admin URL",
+			Sensitive: false,
+		},
+	},
+	"hydra_client_id": {
+		Type:        framework.TypeString,
+		Description: "The client ID used to authenticate introspection requests to Hydra",
+		Required:    false,
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name:      "Hydra Client ID",
+			Sensitive: false,
+		},
+	},
+	"hydra_client_secret": {
+		Type:        framework.TypeString,
+		Description: "The client secret used to authenticate introspection requests to Hydra",
+		Required:    false,
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name:      "Hydra Client Secret",
+			Sensitive: true,
+		},
+	},
+	"hydra_required_scopes": {
+		Type:        framework.TypeCommaStringSlice,
+		Description: "Scopes that an access token must carry to be allowed to log in",
+		Required:    false,
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name:      "Hydra Required Scopes",
+			Sensitive: false,
+		},
+	},
+
+	// jwt
+	"jwt_jwks": {
+		Type:        framework.TypeString,
+		Description: "An inline JSON Web Key Set used to verify login JWTs",
+		Required:    false,
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name:      "JWT JWKS",
+			Sensitive: false,
+		},
+	},
+	"jwt_jwks_url": {
+		Type:        framework.TypeString,
+		Description: "The URL of a JSON Web Key Set used to verify login JWTs, refreshed periodically",
+		Required:    false,
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name:      "JWT JWKS URL",
+			Sensitive: false,
+		},
+	},
+	"jwt_issuer": {
+		Type:        framework.TypeString,
+		Description: "The issuer (iss) that login JWTs must have",
+		Required:    false,
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name:      "JWT Issuer",
+			Sensitive: false,
+		},
+	},
+	"jwt_audiences": {
+		Type:        framework.TypeCommaStringSlice,
+		Description: "Audiences (aud), one of which login JWTs must have. Required if jwt_jwks or jwt_jwks_url is set",
+		Required:    false,
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name:      "JWT Audiences",
+			Sensitive: false,
+		},
+	},
+	"jwt_subject_claim": {
+		Type:        framework.TypeString,
+		Description: "The JWT claim used as the Keto subject",
+		Required:    false,
+		Default:     defaultJWTSubjectClaim,
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name:      "JWT Subject Claim",
+			Sensitive: false,
+		},
+	},
+}
+
+// NewPathConfig creates a new path for configuring the backend.
+func NewPathConfig(b *OryAuthBackend) []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: "config",
+			Fields:  configFields,
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.CreateOperation: b.createConfigHandler,
+				logical.ReadOperation:   b.readConfigHandler,
+				logical.UpdateOperation: b.updateConfigHandler,
+				logical.DeleteOperation: b.deleteConfigHandler,
+			},
+			HelpSynopsis:    configSynopsis,
+			HelpDescription: configDescription,
+		},
+	}
+}
+
+// createConfigHandler creates the configuration in the storage.
+func (b *OryAuthBackend) createConfigHandler(
+	ctx context.Context,
+	req *logical.Request,
+	data *framework.FieldData,
+) (*logical.Response, error) {
+	config := &Config{}
+
+	err := b.decodeFieldData(config, data)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	err = b.setConfig(ctx, req.Storage, config)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create config")
+	}
+
+	b.closeKratosClient()
+	b.closeKratosAdminClient()
+	b.closeKratosBreaker()
+	b.closeKetoClient()
+	b.closeHydraClient()
+	b.closeJWKS()
+
+	return nil, nil
+}
+
+// readConfigHandler reads the configuration from the storage.
+func (b *OryAuthBackend) readConfigHandler(
+	ctx context.Context,
+	req *logical.Request,
+	data *framework.FieldData,
+) (*logical.Response, error) {
+	config, err := b.readConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	if config == nil {
+		return nil, errors.New("config was nil")
+	}
+
+	jsonData, err := json.Marshal(config)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not marshal config to JSON")
+	}
+
+	var response map[string]interface{}
+	err = json.Unmarshal(jsonData, &response)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not unmarshal JSON")
+	}
+
+	for _, field := range sensitiveConfigFields {
+		delete(response, field)
+	}
+
+	if ketoConfig, ok := response["keto"].(map[string]interface{}); ok {
+		for _, field := range sensitiveKetoConfigFields {
+			delete(ketoConfig, field)
+		}
+	}
+
+	return &logical.Response{
+		Data: response,
+	}, nil
+}
+
+// updateConfigHandler updates the configuration in the storage.
+func (b *OryAuthBackend) updateConfigHandler(
+	ctx context.Context,
+	req *logical.Request,
+	data *framework.FieldData,
+) (*logical.Response, error) {
+	config, err := b.readConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	if config == nil {
+		config = &Config{}
+	}
+
+	err = b.decodeFieldData(config, data)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	err = b.setConfig(ctx, req.Storage, config)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to update config")
+	}
+
+	b.closeKratosClient()
+	b.closeKratosAdminClient()
+	b.closeKratosBreaker()
+	b.closeKetoClient()
+	b.closeHydraClient()
+	b.closeJWKS()
+
+	return nil, nil
+}
+
+// deleteConfigHandler deletes the configuration in the storage.
+func (b *OryAuthBackend) deleteConfigHandler(
+	ctx context.Context,
+	req *logical.Request,
+	data *framework.FieldData,
+) (*logical.Response, error) {
+	return nil, req.Storage.Delete(ctx, "config")
+}
+
+// decodeFieldData decodes the incoming config field data and sets the values in the config struct
+func (b *OryAuthBackend) decodeFieldData(config *Config, data *framework.FieldData) error {
+	if config == nil {
+		return errors.New("nil config used to decode field data")
+	}
+	if data == nil {
+		return errors.New("nil data used to decode field data")
+	}
+
+	// plugin configs
+	if val, ok := data.GetOk("use_session_expiry_ttl"); ok {
+		b.Logger().Debug("got config value", "use_session_expiry_ttl", val)
+
+		config.UseSessionExpiryTTL = val.(bool)
+		if !ok {
+			b.Logger().Error(fmt.Sprintf("use_session_expiry_ttl was a %T, expected a bool", val))
+		}
+	}
+
+	if val, ok := data.GetOk("ttl_seconds"); ok {
+		b.Logger().Debug("got config value", "ttl_seconds", val)
+
+		config.TTLSeconds, ok = val.(int)
+		if !ok {
+			b.Logger().Error(fmt.Sprintf("ttl_seconds was a %T, expected int", val))
+		}
+	}
+
+	if val, ok := data.GetOk("max_ttl_seconds"); ok {
+		b.Logger().Debug("got config value", "max_ttl_seconds", val)
+
+		config.MaxTTLSeconds, ok = val.(int)
+		if !ok {
+			b.Logger().Error(fmt.Sprintf("max_ttl_seconds was a %T, expected int", val))
+		}
+	}
+
+	if val, ok := data.GetOk("require_role"); ok {
+		b.Logger().Debug("got config value", "require_role", val)
+
+		config.RequireRole, ok = val.(bool)
+		if !ok {
+			b.Logger().Error(fmt.Sprintf("require_role was a %T, expected a bool", val))
+		}
+	}
+
+	if val, ok := data.GetOk("allow_partial_tuples"); ok {
+		b.Logger().Debug("got config value", "allow_partial_tuples", val)
+
+		config.AllowPartialTuples, ok = val.(bool)
+		if !ok {
+			b.Logger().Error(fmt.Sprintf("allow_partial_tuples was a %T, expected a bool", val))
+		}
+	}
+
+	if val, ok := data.GetOk("max_listed_objects"); ok {
+		b.Logger().Debug("got config value", "max_listed_objects", val)
+
+		config.MaxListedObjects, ok = val.(int)
+		if !ok {
+			b.Logger().Error(fmt.Sprintf("max_listed_objects was a %T, expected int", val))
+		}
+
+		if config.MaxListedObjects < 0 {
+			return errors.New("max_listed_objects cannot be negative")
+		}
+	}
+
+	if val, ok := data.GetOk("required_aal"); ok {
+		b.Logger().Debug("got config value", "required_aal", val)
+
+		config.RequiredAAL, ok = val.(string)
+		if !ok {
+			b.Logger().Error(fmt.Sprintf("required_aal was a %T, expected a string", val))
+		}
+
+		err := validateAAL(config.RequiredAAL)
+		if err != nil {
+			return errors.Wrap(err, "invalid required_aal")
+		}
+	}
+
+	if val, ok := data.GetOk("namespace_required_aal"); ok {
+		b.Logger().Debug("got config value", "namespace_required_aal", val)
+
+		config.NamespaceRequiredAAL, ok = val.(map[string]string)
+		if !ok {
+			b.Logger().Error(fmt.Sprintf("namespace_required_aal was a %T, expected a map[string]string", val))
+		}
+
+		for namespace, aal := range config.NamespaceRequiredAAL {
+			err := validateAAL(aal)
+			if err != nil {
+				return errors.Wrapf(err, "invalid namespace_required_aal for namespace %q", namespace)
+			}
+		}
+	}
+
+	if val, ok := data.GetOk("max_session_age"); ok {
+		b.Logger().Debug("got config value", "max_session_age", val)
+
+		config.MaxSessionAge, ok = val.(int)
+		if !ok {
+			b.Logger().Error(fmt.Sprintf("max_session_age was a %T, expected int", val))
+		}
+	}
+
+	if val, ok := data.GetOk("session_clock_skew"); ok {
+		b.Logger().Debug("got config value", "session_clock_skew", val)
+
+		config.SessionClockSkew, ok = val.(int)
+		if !ok {
+			b.Logger().Error(fmt.Sprintf("session_clock_skew was a %T, expected int", val))
+		}
+	}
+
+	if val, ok := data.GetOk("namespace_max_session_age"); ok {
+		b.Logger().Debug("got config value", "namespace_max_session_age", val)
+
+		rawMaxAges, ok := val.(map[string]string)
+		if !ok {
+			b.Logger().Error(fmt.Sprintf("namespace_max_session_age was a %T, expected a map[string]string", val))
+		}
+
+		config.NamespaceMaxSessionAge = make(map[string]int, len(rawMaxAges))
+		for namespace, rawMaxAge := range rawMaxAges {
+			maxAge, err := parseutil.ParseDurationSecond(rawMaxAge)
+			if err != nil {
+				return errors.Wrapf(err, "invalid namespace_max_session_age for namespace %q", namespace)
+			}
+
+			config.NamespaceMaxSessionAge[namespace] = int(maxAge.Seconds())
+		}
+	}
+
+	if val, ok := data.GetOk("require_active_identity"); ok {
+		b.Logger().Debug("got config value", "require_active_identity", val)
+
+		config.RequireActiveIdentity, ok = val.(bool)
+		if !ok {
+			b.Logger().Error(fmt.Sprintf("require_active_identity was a %T, expected a bool", val))
+		}
+	}
+
+	if val, ok := data.GetOk("require_verified_address"); ok {
+		b.Logger().Debug("got config value", "require_verified_address", val)
+
+		config.RequireVerifiedAddress, ok = val.(bool)
+		if !ok {
+			b.Logger().Error(fmt.Sprintf("require_verified_address was a %T, expected a bool", val))
+		}
+	}
+
+	if val, ok := data.GetOk("required_verified_address_via"); ok {
+		b.Logger().Debug("got config value", "required_verified_address_via", val)
+
+		config.RequiredVerifiedAddressVia, ok = val.(string)
+		if !ok {
+			b.Logger().Error(fmt.Sprintf("required_verified_address_via was a %T, expected a string", val))
+		}
+	}
+
+	if val, ok := data.GetOk("allowed_schema_ids"); ok {
+		b.Logger().Debug("got config value", "allowed_schema_ids", val)
+
+		config.AllowedSchemaIDs, ok = val.([]string)
+		if !ok {
+			b.Logger().Error(fmt.Sprintf("allowed_schema_ids was a %T, expected a []string", val))
+		}
+	}
+
+	if val, ok := data.GetOk("group_relations"); ok {
+		b.Logger().Debug("got config value", "group_relations", val)
+
+		config.GroupRelations, ok = val.([]string)
+		if !ok {
+			b.Logger().Error(fmt.Sprintf("group_relations was a %T, expected a []string", val))
+		}
+
+		err := validateGroupRelations(config.GroupRelations)
+		if err != nil {
+			return errors.Wrap(err, "invalid group_relations")
+		}
+	}
+
+	if val, ok := data.GetOk("alias_name_source"); ok {
+		b.Logger().Debug("got config value", "alias_name_source", val)
+
+		config.AliasNameSource, ok = val.(string)
+		if !ok {
+			b.Logger().Error(fmt.Sprintf("alias_name_source was a %T, expected a string", val))
+		}
+
+		err := validateAliasNameSource(config.AliasNameSource)
+		if err != nil {
+			return err
+		}
+	}
+
+	if val, ok := data.GetOk("trait_metadata"); ok {
+		b.Logger().Debug("got config value", "trait_metadata", val)
+
+		config.TraitMetadata, ok = val.(map[string]string)
+		if !ok {
+			b.Logger().Error(fmt.Sprintf("trait_metadata was a %T, expected a map[string]string", val))
+		}
+
+		err := validateTraitMetadata(config.TraitMetadata)
+		if err != nil {
+			return err
+		}
+	}
+
+	if val, ok := data.GetOk("identity_policies_path"); ok {
+		b.Logger().Debug("got config value", "identity_policies_path", val)
+
+		config.IdentityPoliciesPath, ok = val.(string)
+		if !ok {
+			b.Logger().Error(fmt.Sprintf("identity_policies_path was a %T, expected a string", val))
+		}
+
+		err := validateIdentityPoliciesPath(config.IdentityPoliciesPath)
+		if err != nil {
+			return err
+		}
+	}
+
+	if val, ok := data.GetOk("allowed_identity_policies"); ok {
+		b.Logger().Debug("got config value", "allowed_identity_policies", val)
+
+		config.AllowedIdentityPolicies, ok = val.([]string)
+		if !ok {
+			b.Logger().Error(fmt.Sprintf("allowed_identity_policies was a %T, expected a []string", val))
+		}
+	}
+
+	if val, ok := data.GetOk("relation_hierarchy"); ok {
+		b.Logger().Debug("got config value", "relation_hierarchy", val)
+
+		rawHierarchy, ok := val.(map[string]interface{})
+		if !ok {
+			b.Logger().Error(fmt.Sprintf("relation_hierarchy was a %T, expected a map[string]interface{}", val))
+		}
+
+		hierarchy, err := toStringSliceMap(rawHierarchy)
+		if err != nil {
+			return errors.Wrap(err, "invalid relation_hierarchy")
+		}
+
+		config.RelationHierarchy = hierarchy
 	}
-}
 
-// createConfigHandler creates the configuration in the storage.
-func (b *OryAuthBackend) createConfigHandler(
-	ctx context.Context,
-	req *logical.Request,
-	data *framework.FieldData,
-) (*logical.Response, error) {
-	config := &Config{}
+	// ory network configs
+	if val, ok := data.GetOk("ory_project_slug"); ok {
+		b.Logger().Debug("got config value", "ory_project_slug", val)
 
-	err := b.decodeFieldData(config, data)
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to decode field data during create")
-	}
+		config.OryProjectSlug, ok = val.(string)
+		if !ok {
+			b.Logger().Error(fmt.Sprintf("ory_project_slug was a %T, expected a string", val))
+		}
 
-	err = b.setConfig(ctx, req.Storage, config)
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to create config")
+		err := validateProjectSlug(config.OryProjectSlug)
+		if err != nil {
+			return err
+		}
 	}
 
-	b.closeKratosClient()
-	b.closeKetoClient()
+	if val, ok := data.GetOk("ory_project_url"); ok {
+		b.Logger().Debug("got config value", "ory_project_url", val)
 
-	return nil, nil
-}
+		config.OryProjectURL, ok = val.(string)
+		if !ok {
+			b.Logger().Error(fmt.Sprintf("ory_project_url was a %T, expected a string", val))
+		}
 
-// readConfigHandler reads the configuration from the storage.
-func (b *OryAuthBackend) readConfigHandler(
-	ctx context.Context,
-	req *logical.Request,
-	data *framework.FieldData,
-) (*logical.Response, error) {
-	config, err := b.readConfig(ctx, req.Storage)
-	if err != nil {
-		return nil, err
+		err := validateProjectURL(config.OryProjectURL)
+		if err != nil {
+			return err
+		}
 	}
 
-	if config == nil {
-		return nil, errors.New("config was nil")
-	}
+	if val, ok := data.GetOk("ory_api_key"); ok {
+		b.Logger().Debug("got config value", "ory_api_key", "[redacted]")
 
-	jsonData, err := json.Marshal(config)
-	if err != nil {
-		return nil, errors.Wrap(err, "could not marshal config to JSON")
+		config.OryAPIKey, ok = val.(string)
+		if !ok {
+			b.Logger().Error(fmt.Sprintf("ory_api_key was a %T, expected a string", val))
+		}
 	}
 
-	var response map[string]interface{}
-	err = json.Unmarshal(jsonData, &response)
-	if err != nil {
-		return nil, errors.Wrap(err, "could not unmarshal JSON")
+	// keto configs
+	if config.Keto == nil {
+		config.Keto = &KetoConfig{}
 	}
 
-	return &logical.Response{
-		Data: response,
-	}, nil
-}
+	if val, ok := data.GetOk("keto_host"); ok {
+		b.Logger().Warn("keto_host is deprecated, use keto_read_addr instead")
 
-// updateConfigHandler updates the configuration in the storage.
-func (b *OryAuthBackend) updateConfigHandler(
-	ctx context.Context,
-	req *logical.Request,
-	data *framework.FieldData,
-) (*logical.Response, error) {
-	config, err := b.readConfig(ctx, req.Storage)
-	if err != nil {
-		return nil, err
+		config.Keto.ReadAddr, ok = val.(string)
+		if !ok {
+			b.Logger().Error(fmt.Sprintf("keto_host was a %T, expected a string", val))
+		}
 	}
 
-	if config == nil {
-		config = &Config{}
-	}
+	if val, ok := data.GetOk("keto_read_addr"); ok {
+		b.Logger().Debug("got config value", "keto_read_addr", val)
 
-	err = b.decodeFieldData(config, data)
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to decode field data during update")
+		config.Keto.ReadAddr, ok = val.(string)
+		if !ok {
+			b.Logger().Error(fmt.Sprintf("keto_read_addr was a %T, expected a string", val))
+		}
 	}
 
-	err = b.setConfig(ctx, req.Storage, config)
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to update config")
+	if val, ok := data.GetOk("keto_write_addr"); ok {
+		b.Logger().Debug("got config value", "keto_write_addr", val)
+
+		config.Keto.WriteAddr, ok = val.(string)
+		if !ok {
+			b.Logger().Error(fmt.Sprintf("keto_write_addr was a %T, expected a string", val))
+		}
 	}
 
-	b.closeKratosClient()
-	b.closeKetoClient()
+	if val, ok := data.GetOk("keto_transport"); ok {
+		b.Logger().Debug("got config value", "keto_transport", val)
 
-	return nil, nil
-}
+		config.Keto.Transport, ok = val.(string)
+		if !ok {
+			b.Logger().Error(fmt.Sprintf("keto_transport was a %T, expected a string", val))
+		}
 
-// deleteConfigHandler deletes the configuration in the storage.
-func (b *OryAuthBackend) deleteConfigHandler(
-	ctx context.Context,
-	req *logical.Request,
-	data *framework.FieldData,
-) (*logical.Response, error) {
-	return nil, req.Storage.Delete(ctx, "config")
-}
+		err := validateKetoTransportName(config.Keto.Transport)
+		if err != nil {
+			return err
+		}
+	}
 
-// decodeFieldData decodes the incoming config field data and sets the values in the config struct
-func (b *OryAuthBackend) decodeFieldData(config *Config, data *framework.FieldData) error {
-	if config == nil {
-		return errors.New("nil config used to decode field data")
+	if val, ok := data.GetOk("keto_read_timeout"); ok {
+		b.Logger().Debug("got config value", "keto_read_timeout", val)
+
+		config.Keto.ReadTimeoutSeconds, ok = val.(int)
+		if !ok {
+			b.Logger().Error(fmt.Sprintf("keto_read_timeout was a %T, expected int", val))
+		}
 	}
-	if data == nil {
-		return errors.New("nil data used to decode field data")
+
+	if val, ok := data.GetOk("keto_ca_cert"); ok {
+		b.Logger().Debug("got config value", "keto_ca_cert", "[redacted]")
+
+		config.Keto.CACert, ok = val.(string)
+		if !ok {
+			b.Logger().Error(fmt.Sprintf("keto_ca_cert was a %T, expected a string", val))
+		}
 	}
 
-	// plugin configs
-	if val, ok := data.GetOk("use_session_expiry_ttl"); ok {
-		b.Logger().Debug("got config value", "use_session_expiry_ttl", val)
+	if val, ok := data.GetOk("keto_client_cert"); ok {
+		b.Logger().Debug("got config value", "keto_client_cert", "[redacted]")
 
-		config.UseSessionExpiryTTL = val.(bool)
+		config.Keto.ClientCert, ok = val.(string)
 		if !ok {
-			b.Logger().Error(fmt.Sprintf("use_session_expiry_ttl was a %T, expected a bool", val))
+			b.Logger().Error(fmt.Sprintf("keto_client_cert was a %T, expected a string", val))
 		}
 	}
 
-	if val, ok := data.GetOk("ttl_seconds"); ok {
-		b.Logger().Debug("got config value", "ttl_seconds", val)
+	if val, ok := data.GetOk("keto_client_key"); ok {
+		b.Logger().Debug("got config value", "keto_client_key", "[redacted]")
 
-		config.TTLSeconds, ok = val.(int)
+		config.Keto.ClientKey, ok = val.(string)
 		if !ok {
-			b.Logger().Error(fmt.Sprintf("ttl_seconds was a %T, expected int", val))
+			b.Logger().Error(fmt.Sprintf("keto_client_key was a %T, expected a string", val))
 		}
 	}
 
-	if val, ok := data.GetOk("max_ttl_seconds"); ok {
-		b.Logger().Debug("got config value", "max_ttl_seconds", val)
+	if val, ok := data.GetOk("keto_tls_server_name"); ok {
+		b.Logger().Debug("got config value", "keto_tls_server_name", val)
 
-		config.MaxTTLSeconds, ok = val.(int)
+		config.Keto.TLSServerName, ok = val.(string)
 		if !ok {
-			b.Logger().Error(fmt.Sprintf("max_ttl_seconds was a %T, expected int", val))
+			b.Logger().Error(fmt.Sprintf("keto_tls_server_name was a %T, expected a string", val))
 		}
 	}
 
-	// keto configs
-	if val, ok := data.GetOk("keto_host"); ok {
-		b.Logger().Debug("got config value", "keto_host", val)
-		config.KetoHost, ok = val.(string)
+	if val, ok := data.GetOk("keto_plaintext"); ok {
+		b.Logger().Debug("got config value", "keto_plaintext", val)
+
+		config.Keto.Plaintext, ok = val.(bool)
 		if !ok {
-			b.Logger().Error(fmt.Sprintf("keto_host was a %T, expected a string", val))
+			b.Logger().Error(fmt.Sprintf("keto_plaintext was a %T, expected a bool", val))
 		}
 	}
 
+	err := validateKetoTransport(config.Keto)
+	if err != nil {
+		return errors.Wrap(err, "invalid keto TLS config")
+	}
+
 	// kratos configs
 	if val, ok := data.GetOk("kratos_url"); ok {
 		b.Logger().Debug("got config value", "kratos_url", val)
@@ -316,5 +1193,259 @@ func (b *OryAuthBackend) decodeFieldData(config *Config, data *framework.FieldDa
 		}
 	}
 
+	if val, ok := data.GetOk("kratos_api_version"); ok {
+		b.Logger().Debug("got config value", "kratos_api_version", val)
+
+		config.KratosAPIVersion, ok = val.(string)
+		if !ok {
+			b.Logger().Error(fmt.Sprintf("kratos_api_version was a %T, expected a string", val))
+		}
+
+		err := validateKratosAPIVersion(config.KratosAPIVersion)
+		if err != nil {
+			return err
+		}
+	}
+
+	if val, ok := data.GetOk("kratos_timeout"); ok {
+		b.Logger().Debug("got config value", "kratos_timeout", val)
+
+		config.KratosTimeoutSeconds, ok = val.(int)
+		if !ok {
+			b.Logger().Error(fmt.Sprintf("kratos_timeout was a %T, expected int", val))
+		}
+	}
+
+	if val, ok := data.GetOk("kratos_max_retries"); ok {
+		b.Logger().Debug("got config value", "kratos_max_retries", val)
+
+		maxRetries, ok := val.(int)
+		if !ok {
+			b.Logger().Error(fmt.Sprintf("kratos_max_retries was a %T, expected int", val))
+		}
+
+		if maxRetries < 0 {
+			return errors.New("kratos_max_retries cannot be negative")
+		}
+
+		config.KratosMaxRetries = &maxRetries
+	}
+
+	if val, ok := data.GetOk("kratos_circuit_breaker_threshold"); ok {
+		b.Logger().Debug("got config value", "kratos_circuit_breaker_threshold", val)
+
+		threshold, ok := val.(int)
+		if !ok {
+			b.Logger().Error(fmt.Sprintf("kratos_circuit_breaker_threshold was a %T, expected int", val))
+		}
+
+		if threshold < 0 {
+			return errors.New("kratos_circuit_breaker_threshold cannot be negative")
+		}
+
+		config.KratosCircuitBreakerThreshold = &threshold
+	}
+
+	if val, ok := data.GetOk("kratos_circuit_breaker_cooldown"); ok {
+		b.Logger().Debug("got config value", "kratos_circuit_breaker_cooldown", val)
+
+		config.KratosCircuitBreakerCooldownSeconds, ok = val.(int)
+		if !ok {
+			b.Logger().Error(fmt.Sprintf("kratos_circuit_breaker_cooldown was a %T, expected int", val))
+		}
+	}
+
+	if val, ok := data.GetOk("kratos_ca_cert"); ok {
+		b.Logger().Debug("got config value", "kratos_ca_cert", val)
+
+		config.KratosCACert, ok = val.(string)
+		if !ok {
+			b.Logger().Error(fmt.Sprintf("kratos_ca_cert was a %T, expected a string", val))
+		}
+	}
+
+	if val, ok := data.GetOk("kratos_client_cert"); ok {
+		b.Logger().Debug("got config value", "kratos_client_cert", val)
+
+		config.KratosClientCert, ok = val.(string)
+		if !ok {
+			b.Logger().Error(fmt.Sprintf("kratos_client_cert was a %T, expected a string", val))
+		}
+	}
+
+	if val, ok := data.GetOk("kratos_client_key"); ok {
+		b.Logger().Debug("got config value", "kratos_client_key", "[redacted]")
+
+		config.KratosClientKey, ok = val.(string)
+		if !ok {
+			b.Logger().Error(fmt.Sprintf("kratos_client_key was a %T, expected a string", val))
+		}
+	}
+
+	if val, ok := data.GetOk("kratos_tls_server_name"); ok {
+		b.Logger().Debug("got config value", "kratos_tls_server_name", val)
+
+		config.KratosTLSServerName, ok = val.(string)
+		if !ok {
+			b.Logger().Error(fmt.Sprintf("kratos_tls_server_name was a %T, expected a string", val))
+		}
+	}
+
+	if val, ok := data.GetOk("kratos_tls_min_version"); ok {
+		b.Logger().Debug("got config value", "kratos_tls_min_version", val)
+
+		config.KratosTLSMinVersion, ok = val.(string)
+		if !ok {
+			b.Logger().Error(fmt.Sprintf("kratos_tls_min_version was a %T, expected a string", val))
+		}
+	}
+
+	if val, ok := data.GetOk("kratos_tls_skip_verify"); ok {
+		b.Logger().Debug("got config value", "kratos_tls_skip_verify", val)
+
+		config.KratosTLSSkipVerify, ok = val.(bool)
+		if !ok {
+			b.Logger().Error(fmt.Sprintf("kratos_tls_skip_verify was a %T, expected a bool", val))
+		}
+	}
+
+	_, err = kratosTLSConfig(config)
+	if err != nil {
+		return errors.Wrap(err, "invalid kratos TLS config")
+	}
+
+	if val, ok := data.GetOk("kratos_admin_url"); ok {
+		b.Logger().Debug("got config value", "kratos_admin_url", val)
+
+		config.KratosAdminURL, ok = val.(string)
+		if !ok {
+			b.Logger().Error(fmt.Sprintf("kratos_admin_url was a %T, expected a string", val))
+		}
+	}
+
+	if val, ok := data.GetOk("kratos_admin_api_key"); ok {
+		b.Logger().Debug("got config value", "kratos_admin_api_key", "[redacted]")
+
+		config.KratosAdminAPIKey, ok = val.(string)
+		if !ok {
+			b.Logger().Error(fmt.Sprintf("kratos_admin_api_key was a %T, expected a string", val))
+		}
+	}
+
+	// hydra configs
+	if val, ok := data.GetOk("hydra_admin_url"); ok {
+		b.Logger().Debug("got config value", "hydra_admin_url", val)
+
+		config.HydraAdminURL, ok = val.(string)
+		if !ok {
+			b.Logger().Error(fmt.Sprintf("hydra_admin_url was a %T, expected a string", val))
+		}
+	}
+
+	if val, ok := data.GetOk("hydra_client_id"); ok {
+		b.Logger().Debug("got config value", "hydra_client_id", val)
+
+		config.HydraClientID, ok = val.(string)
+		if !ok {
+			b.Logger().Error(fmt.Sprintf("hydra_client_id was a %T, expected a string", val))
+		}
+	}
+
+	if val, ok := data.GetOk("hydra_client_secret"); ok {
+		b.Logger().Debug("got config value", "hydra_client_secret", "[redacted]")
+
+		config.HydraClientSecret, ok = val.(string)
+		if !ok {
+			b.Logger().Error(fmt.Sprintf("hydra_client_secret was a %T, expected a string", val))
+		}
+	}
+
+	if val, ok := data.GetOk("hydra_required_scopes"); ok {
+		b.Logger().Debug("got config value", "hydra_required_scopes", val)
+
+		config.HydraRequiredScopes, ok = val.([]string)
+		if !ok {
+			b.Logger().Error(fmt.Sprintf("hydra_required_scopes was a %T, expected a []string", val))
+		}
+	}
+
+	// jwt configs
+	if val, ok := data.GetOk("jwt_jwks"); ok {
+		b.Logger().Debug("got config value", "jwt_jwks", val)
+
+		config.JWTJWKS, ok = val.(string)
+		if !ok {
+			b.Logger().Error(fmt.Sprintf("jwt_jwks was a %T, expected a string", val))
+		}
+	}
+
+	if val, ok := data.GetOk("jwt_jwks_url"); ok {
+		b.Logger().Debug("got config value", "jwt_jwks_url", val)
+
+		config.JWTJWKSURL, ok = val.(string)
+		if !ok {
+			b.Logger().Error(fmt.Sprintf("jwt_jwks_url was a %T, expected a string", val))
+		}
+	}
+
+	if val, ok := data.GetOk("jwt_issuer"); ok {
+		b.Logger().Debug("got config value", "jwt_issuer", val)
+
+		config.JWTIssuer, ok = val.(string)
+		if !ok {
+			b.Logger().Error(fmt.Sprintf("jwt_issuer was a %T, expected a string", val))
+		}
+	}
+
+	if val, ok := data.GetOk("jwt_audiences"); ok {
+		b.Logger().Debug("got config value", "jwt_audiences", val)
+
+		config.JWTAudiences, ok = val.([]string)
+		if !ok {
+			b.Logger().Error(fmt.Sprintf("jwt_audiences was a %T, expected a []string", val))
+		}
+	}
+
+	if val, ok := data.GetOk("jwt_subject_claim"); ok {
+		b.Logger().Debug("got config value", "jwt_subject_claim", val)
+
+		config.JWTSubjectClaim, ok = val.(string)
+		if !ok {
+			b.Logger().Error(fmt.Sprintf("jwt_subject_claim was a %T, expected a string", val))
+		}
+	}
+
+	if (config.JWTJWKS != "" || config.JWTJWKSURL != "") && len(config.JWTAudiences) == 0 {
+		return errors.New("jwt_audiences must be set when jwt_jwks or jwt_jwks_url is configured")
+	}
+
 	return nil
 }
+
+// toStringSliceMap converts a map of lists, or of comma-separated strings, to a map of string slices
+func toStringSliceMap(raw map[string]interface{}) (map[string][]string, error) {
+	result := make(map[string][]string, len(raw))
+
+	for key, val := range raw {
+		switch v := val.(type) {
+		case string:
+			result[key] = strutil.ParseStringSlice(v, ",")
+		case []interface{}:
+			items := make([]string, 0, len(v))
+			for _, item := range v {
+				str, ok := item.(string)
+				if !ok {
+					return nil, errors.Errorf("value of %q contained a %T, expected a string", key, item)
+				}
+
+				items = append(items, str)
+			}
+
+			result[key] = items
+		default:
+			return nil, errors.Errorf("value of %q was a %T, expected a list or a comma-separated string", key, val)
+		}
+	}
+
+	return result, nil
+}
@@ -0,0 +1,54 @@
+package plugin
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestConfigInvalidInput(t *testing.T) {
+	tests := []struct {
+		name string
+		data map[string]interface{}
+	}{
+		{
+			name: "negative max_listed_objects",
+			data: map[string]interface{}{"max_listed_objects": -1},
+		},
+		{
+			name: "invalid required_aal",
+			data: map[string]interface{}{"required_aal": "aal9"},
+		},
+		{
+			name: "invalid keto_transport",
+			data: map[string]interface{}{"keto_transport": "udp"},
+		},
+		{
+			name: "jwks without audiences",
+			data: map[string]interface{}{"jwt_jwks_url": "https://oathkeeper.internal/.well-known/jwks.json"},
+		},
+	}
+
+	for _, operation := range []logical.Operation{logical.CreateOperation, logical.UpdateOperation} {
+		for _, tt := range tests {
+			t.Run(string(operation)+"/"+tt.name, func(t *testing.T) {
+				b, storage := newTestBackend(t, &Config{})
+
+				resp, err := b.HandleRequest(context.Background(), &logical.Request{
+					Operation: operation,
+					Path:      "config",
+					Storage:   storage,
+					Data:      tt.data,
+				})
+				if err != nil {
+					t.Fatalf("HandleRequest() error = %v, want an error response", err)
+				}
+
+				if resp == nil || !resp.IsError() {
+					t.Fatalf("HandleRequest() = %v, want an error response", resp)
+				}
+			})
+		}
+	}
+}
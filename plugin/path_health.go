@@ -0,0 +1,82 @@
+package plugin
+
+import (
+	"context"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/pkg/errors"
+)
+
+const (
+	// healthSynopsis is used to provide a short summary of the health path.
+	healthSynopsis = `Reports the health of the Ory services used by the plugin.`
+
+	// healthDescription is used to provide a detailed description of the health path.
+	healthDescription = `
+This endpoint checks that Kratos and Keto can be reached, and reports the state
+of the circuit breaker guarding requests to Kratos ('closed', 'open' or
+'half-open') with its number of consecutive failures.
+`
+)
+
+// NewPathHealth creates the path for reading the health of the Ory services.
+func NewPathHealth(b *OryAuthBackend) []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: "health/?$",
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.ReadOperation: b.readHealthHandler,
+			},
+			HelpSynopsis:    healthSynopsis,
+			HelpDescription: healthDescription,
+		},
+	}
+}
+
+// readHealthHandler checks the health of the Ory services.
+func (b *OryAuthBackend) readHealthHandler(
+	ctx context.Context,
+	req *logical.Request,
+	data *framework.FieldData,
+) (*logical.Response, error) {
+	config, err := b.readConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch config")
+	}
+
+	if config == nil {
+		return logical.ErrorResponse("plugin is not configured"), nil
+	}
+
+	state, failures := b.getKratosBreaker(config).status()
+
+	kratosHealth := map[string]interface{}{
+		"healthy":              true,
+		"circuit_breaker":      state,
+		"consecutive_failures": failures,
+	}
+
+	err = b.checkKratosHealth(ctx, req.Storage)
+	if err != nil {
+		kratosHealth["healthy"] = false
+		kratosHealth["error"] = err.Error()
+	}
+
+	ketoHealth := map[string]interface{}{
+		"healthy": true,
+	}
+
+	err = b.checkKetoHealth(ctx, req.Storage)
+	if err != nil {
+		ketoHealth["healthy"] = false
+		ketoHealth["error"] = err.Error()
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"kratos": kratosHealth,
+			"keto":   ketoHealth,
+		},
+	}, nil
+}
@@ -4,12 +4,11 @@ import (
 	"context"
 	"net/http"
 	"strings"
-	"time"
 
 	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/strutil"
 	"github.com/hashicorp/vault/sdk/logical"
 
-	keto "github.com/ory/keto/proto/ory/keto/relation_tuples/v1alpha2"
 	kratos "github.com/ory/kratos-client-go"
 
 	"github.com/pkg/errors"
@@ -23,40 +22,77 @@ Authenticates Ory Kratos users with Vault and authorises a policy with Keto.
 
 	// pathLoginDesc is used to generate the help text for the login path.
 	pathLoginDescription = `
-Authenticate Ory Kratos identities using a Kratos session cookie.
+Authenticate Ory Kratos identities using a Kratos session cookie or session token,
+Ory Hydra clients using an OAuth2 access token, or callers holding a signed JWT
+(e.g. a Kratos tokenized session or an Oathkeeper id_token).
 Authorise the identity with Keto using a namespace, object and relation.
 Resulting policy is named after the namespace and relation in the format
 namespace_relation.
 `
 )
 
+var loginFields map[string]*framework.FieldSchema = map[string]*framework.FieldSchema{
+	"kratos_session_cookie": {
+		Type: framework.TypeString,
+		Description: `The Kratos session cookie.
+This is the full session cookie (e.g. 'ory_kratos_session=...'), or just its value, in which case
+the cookie name of the Kratos instance or Ory Network project is added.
+Exactly one of 'kratos_session_cookie', 'kratos_session_token', 'access_token' or 'jwt' must be specified.`,
+	},
+	"kratos_session_token": {
+		Type: framework.TypeString,
+		Description: `The Kratos session token.
+This is the session token issued by Kratos native (API) flows, sent as X-Session-Token.
+Exactly one of 'kratos_session_cookie', 'kratos_session_token', 'access_token' or 'jwt' must be specified.`,
+	},
+	"access_token": {
+		Type: framework.TypeString,
+		Description: `An Ory Hydra OAuth2 access token.
+The token is introspected with Hydra and its 'sub' (or 'client_id') is used as the Keto subject.`,
+	},
+	"jwt": {
+		Type: framework.TypeString,
+		Description: `A signed JWT, such as a Kratos tokenized session or an Oathkeeper id_token.
+The JWT is verified locally against the configured JWKS and its subject claim is used as the Keto subject.`,
+	},
+	"role": {
+		Type: framework.TypeString,
+		Description: `The name of the role to log in with.
+The role restricts the namespace, object and relation that may be requested.
+If 'require_role' is set in the config and 'role' is not specified, login fails.`,
+	},
+	"tuples": {
+		Type: framework.TypeSlice,
+		Description: `A list of Keto relation tuples to authenticate against in one login.
+Each tuple is either an object with 'namespace', 'object' and 'relation' keys, or a string
+in the format 'namespace:object#relation'. The relation may be omitted to use the highest relation
+in the namespace's 'relation_hierarchy'. Cannot be combined with 'namespace', 'object' and 'relation'.`,
+	},
+	"namespace": {
+		Type: framework.TypeString,
+		Description: `Keto namespace of the resource being authenticated against.
+If neither 'namespace' nor 'tuples' is specified, login fails.`,
+	},
+	"object": {
+		Type: framework.TypeString,
+		Description: `Keto object being authenticated against.
+If 'object' is not specified, every object in the namespace that the subject has the relation to
+is listed from Keto and granted, up to the configured 'max_listed_objects'. This requires 'relation'.`,
+	},
+	"relation": {
+		Type: framework.TypeString,
+		Description: `Keto relation between subject and object being authenticated against.
+If 'relation' is not specified, the highest relation the subject has in the namespace's
+configured 'relation_hierarchy' is used. If the namespace has no hierarchy, login fails.`,
+	},
+}
+
 // NewPathLogin returns the path for the login endpoint.
 func NewPathLogin(b *OryAuthBackend) []*framework.Path {
 	return []*framework.Path{
 		{
 			Pattern: "login$",
-			Fields: map[string]*framework.FieldSchema{
-				"kratos_session_cookie": {
-					Type: framework.TypeString,
-					Description: `The Kratos session cookie.
-This is the value of the Kratos session cookie.`,
-				},
-				"namespace": {
-					Type: framework.TypeString,
-					Description: `Keto namespace of the resource being authenticated against.
-If 'namespace' is not specified, login fails.`,
-				},
-				"object": {
-					Type: framework.TypeString,
-					Description: `Keto object being authenticated against.
-If 'object' is not specified, login fails.`,
-				},
-				"relation": {
-					Type: framework.TypeString,
-					Description: `Keto relation between subject and object being authenticated against.
-If 'relation' is not specified, login fails.`,
-				},
-			},
+			Fields:  loginFields,
 			Callbacks: map[logical.Operation]framework.OperationFunc{
 				logical.UpdateOperation: b.loginUpdateHandler,
 			},
@@ -74,86 +110,156 @@ func (b *OryAuthBackend) loginUpdateHandler(
 ) (*logical.Response, error) {
 	b.Logger().Debug("pathLoginUpdate called")
 
-	kratosSession, err := b.getKratosSession(ctx, req, data)
+	config, err := b.readConfig(ctx, req.Storage)
 	if err != nil {
-		return logical.ErrorResponse(err.Error()), nil
+		return nil, errors.Wrap(err, "failed to fetch config")
+	}
+
+	if config == nil {
+		return nil, errors.New("plugin is not configured")
 	}
 
-	namespace, err := b.getNamespace(data)
+	roleName, role, err := b.getRole(ctx, req, data, config)
 	if err != nil {
-		return logical.ErrorResponse(err.Error()), nil
+		return loginErrorResponse(err, errBadRequest)
 	}
 
-	object, err := b.getObject(data)
+	principal, err := b.getPrincipal(ctx, req, data, config)
 	if err != nil {
-		return logical.ErrorResponse(err.Error()), nil
+		return loginErrorResponse(err, errSessionInvalid)
 	}
 
-	relation, err := b.getRelation(data)
+	tuples, err := b.getTuples(data)
 	if err != nil {
-		return logical.ErrorResponse(err.Error()), nil
+		return loginErrorResponse(err, errBadRequest)
 	}
 
-	subject, err := b.getSubject(kratosSession)
+	// a login without an object is granted every object the subject has the relation to
+	listed := len(tuples) == 1 && tuples[0].Object == ""
+	if listed {
+		tuples, err = b.listTuples(ctx, req, config, role, tuples[0], principal.subject)
+		if err != nil {
+			return loginErrorResponse(err, errUpstreamUnavailable)
+		}
+	}
+
+	if role != nil {
+		for _, tuple := range tuples {
+			err = role.allows(tuple.Namespace, tuple.Object, tuple.Relation)
+			if err != nil {
+				return loginErrorResponse(err, errRelationDenied)
+			}
+		}
+	}
+
+	freshFor, err := b.checkPrincipal(config, principal, tuples)
 	if err != nil {
-		return logical.ErrorResponse(err.Error()), nil
+		return loginErrorResponse(err, errSessionInvalid)
+	}
+
+	subject := principal.subject
+
+	// listed tuples were read from Keto, so they are granted without checking them again
+	granted := tuples
+	if !listed {
+		candidates, err := b.relationCandidates(config, role, tuples)
+		if err != nil {
+			return loginErrorResponse(err, errBadRequest)
+		}
+
+		granted, err = b.checkTuples(ctx, req, tuples, candidates, subject, config.AllowPartialTuples)
+		if err != nil {
+			return loginErrorResponse(err, errRelationDenied)
+		}
 	}
 
-	// TODO do we replace with List call and create policies for all relations?
-	allowed, err := b.checkRelation(ctx, req, namespace, object, relation, subject)
+	policies := tuplesToPolicies(granted)
+
+	identityPolicies, err := b.getIdentityPolicies(ctx, req.Storage, config, principal)
 	if err != nil {
-		return logical.ErrorResponse(err.Error()), nil
+		return loginErrorResponse(errors.Wrap(err, "failed to get identity policies"), errUpstreamUnavailable)
 	}
+	policies = strutil.RemoveDuplicatesStable(append(policies, identityPolicies...), false)
 
-	if !allowed {
-		return logical.ErrorResponse(
-			"subject does not have the relation to the object in the namespace",
-		), nil
+	metadata, err := b.getTraitMetadata(ctx, req.Storage, config, principal)
+	if err != nil {
+		return loginErrorResponse(errors.Wrap(err, "failed to get trait metadata"), errUpstreamUnavailable)
 	}
+	for key, val := range tuplesToMetadata(granted) {
+		metadata[key] = val
+	}
+	metadata["subject"] = subject
 
-	policy := strings.Join([]string{namespace, relation}, "_")
-	policies := []string{policy}
+	if listed {
+		objects := make([]string, 0, len(granted))
+		for _, tuple := range granted {
+			objects = append(objects, tuple.Object)
+		}
 
-	metadata := map[string]string{
-		"namespace": namespace,
-		"object":    object,
-		"relation":  relation,
-		"subject":   subject,
+		metadata["objects"] = strings.Join(objects, ",")
+	}
+
+	if principal.session != nil {
+		metadata["schema_id"] = principal.session.Identity.SchemaId
 	}
 
 	internalData := map[string]interface{}{
-		"namespace": namespace,
-		"object":    object,
-		"relation":  relation,
-		"subject":   subject,
+		"namespace":    granted[0].Namespace,
+		"object":       granted[0].Object,
+		"relation":     granted[0].Relation,
+		"tuples":       tuplesToInternalData(granted),
+		"subject":      subject,
+		"login_method": principal.method,
 	}
 
-	config, err := b.readConfig(ctx, req.Storage)
+	if len(identityPolicies) > 0 {
+		internalData["identity_policies"] = strings.Join(identityPolicies, ",")
+	}
+
+	aliasName, err := b.getAliasName(config, principal)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to fetch config")
+		return loginErrorResponse(err, errSessionInvalid)
 	}
 
-	var ttl time.Duration
-	if config.UseSessionExpiryTTL {
-		ttl = time.Until(*kratosSession.ExpiresAt)
-	} else {
-		ttl = time.Duration(config.TTLSeconds) * time.Second
+	groupAliases, err := b.getGroupAliases(ctx, req, config, subject)
+	if err != nil {
+		return loginErrorResponse(err, errUpstreamUnavailable)
+	}
+
+	ttl, maxTTL := principal.tokenTTLs(config, role, freshFor)
+
+	var tokenType logical.TokenType
+	if role != nil {
+		policies = append(policies, role.TokenPolicies...)
+		metadata["role"] = roleName
+		internalData["role"] = roleName
+
+		tokenType, err = parseTokenType(role.TokenType)
+		if err != nil {
+			return nil, errors.Wrap(err, "role has an invalid token type")
+		}
 	}
 
+	// the credential is kept in the internal data, which is never returned to the client,
+	// so that the caller can be re-authenticated when the token is renewed
+	internalData[principal.credentialField] = principal.credential
+
 	res := &logical.Response{
 		Auth: &logical.Auth{
-			Period: ttl,
 			Alias: &logical.Alias{
-				Name:     "ory-auth",
+				Name:     aliasName,
 				Metadata: metadata,
 			},
+			GroupAliases: groupAliases,
 			Policies:     policies,
 			InternalData: internalData,
-			DisplayName:  "kratos-keto",
+			DisplayName:  principal.method + "-keto",
+			TokenType:    tokenType,
 			LeaseOptions: logical.LeaseOptions{
-				Renewable: false,
+				// batch tokens cannot be renewed
+				Renewable: tokenType != logical.TokenTypeBatch,
 				TTL:       ttl,
-				MaxTTL:    time.Duration(config.MaxTTLSeconds) * time.Second,
+				MaxTTL:    maxTTL,
 			},
 		},
 	}
@@ -161,31 +267,209 @@ func (b *OryAuthBackend) loginUpdateHandler(
 	return res, nil
 }
 
-// getKratosSession returns the Kratos session from the request.
-func (b *OryAuthBackend) getKratosSession(
+// authRenewHandler is the handler for renewing tokens issued by the login path.
+//
+// The caller is re-authenticated with the credential used to log in, and every granted
+// relation tuple is checked with Keto again, so that revoked sessions and removed
+// relations stop the token from being renewed.
+func (b *OryAuthBackend) authRenewHandler(
 	ctx context.Context,
 	req *logical.Request,
 	data *framework.FieldData,
-) (*kratos.Session, error) {
-	val, ok := data.GetOk("kratos_session_cookie")
+) (*logical.Response, error) {
+	b.Logger().Debug("authRenewHandler called")
+
+	if req.Auth == nil {
+		return nil, errors.New("request auth was nil")
+	}
+
+	config, err := b.readConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch config")
+	}
+
+	if config == nil {
+		return nil, errors.New("plugin is not configured")
+	}
+
+	internalData := req.Auth.InternalData
+
+	credentialData := &framework.FieldData{
+		Raw:    make(map[string]interface{}),
+		Schema: loginFields,
+	}
+
+	for _, field := range loginCredentialFields {
+		if val, ok := internalData[field]; ok {
+			credentialData.Raw[field] = val
+		}
+	}
+
+	principal, err := b.getPrincipal(ctx, req, credentialData, config)
+	if err != nil {
+		return loginErrorResponse(errors.Wrap(err, "failed to re-authenticate"), errSessionInvalid)
+	}
+
+	subject, _ := internalData["subject"].(string)
+	if principal.subject != subject {
+		return loginErrorResponse(errors.New("subject of the credential has changed"), errSessionInvalid)
+	}
+
+	tuples, err := getInternalTuples(internalData)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read tuples from internal data")
+	}
+
+	var role *Role
+	if roleName, ok := internalData["role"].(string); ok && roleName != "" {
+		role, err = b.readRole(ctx, req.Storage, roleName)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read role")
+		}
+
+		if role == nil {
+			return loginErrorResponse(errors.Errorf("role %q no longer exists", roleName), errRelationDenied)
+		}
+
+		for _, tuple := range tuples {
+			err = role.allows(tuple.Namespace, tuple.Object, tuple.Relation)
+			if err != nil {
+				return loginErrorResponse(err, errRelationDenied)
+			}
+		}
+	}
+
+	freshFor, err := b.checkPrincipal(config, principal, tuples)
+	if err != nil {
+		return loginErrorResponse(err, errSessionInvalid)
+	}
+
+	// the granted relations are checked exactly, rather than resolved from the hierarchy again
+	candidates := make([][]string, len(tuples))
+	for i, tuple := range tuples {
+		candidates[i] = []string{tuple.Relation}
+	}
+
+	_, err = b.checkTuples(ctx, req, tuples, candidates, subject, false)
+	if err != nil {
+		return loginErrorResponse(err, errRelationDenied)
+	}
+
+	// the policies of a token cannot change on renewal, so it is refused if any was removed from the identity
+	if granted, ok := internalData["identity_policies"].(string); ok && granted != "" {
+		identityPolicies, err := b.getIdentityPolicies(ctx, req.Storage, config, principal)
+		if err != nil {
+			return loginErrorResponse(errors.Wrap(err, "failed to get identity policies"), errUpstreamUnavailable)
+		}
+
+		for _, policy := range strutil.ParseStringSlice(granted, ",") {
+			if !strutil.StrListContains(identityPolicies, policy) {
+				return loginErrorResponse(
+					errDenied("identity_policy_revoked", errors.Errorf("identity policy %q is no longer granted", policy)),
+					errSessionInvalid,
+				)
+			}
+		}
+	}
+
+	ttl, maxTTL := principal.tokenTTLs(config, role, freshFor)
+
+	// group memberships are resolved again, so that the external groups of the entity follow Keto
+	groupAliases, err := b.getGroupAliases(ctx, req, config, subject)
+	if err != nil {
+		return loginErrorResponse(err, errUpstreamUnavailable)
+	}
+
+	res := &logical.Response{Auth: req.Auth}
+	res.Auth.TTL = ttl
+	res.Auth.MaxTTL = maxTTL
+	res.Auth.GroupAliases = groupAliases
+
+	return res, nil
+}
+
+// getRole returns the role named in the request, if any.
+func (b *OryAuthBackend) getRole(
+	ctx context.Context,
+	req *logical.Request,
+	data *framework.FieldData,
+	config *Config,
+) (string, *Role, error) {
+	b.Logger().Debug("getting role from data")
+
+	val, ok := data.GetOk("role")
 	if !ok {
-		return nil, errors.New("kratos_session_cookie is required")
+		if config.RequireRole {
+			return "", nil, errors.New("role is required")
+		}
+
+		return "", nil, nil
 	}
 
-	kratosSessionCookie, ok := val.(string)
-	if !ok || kratosSessionCookie == "" {
-		return nil, errors.New("missing kratos_session_cookie")
+	roleName, ok := val.(string)
+	if !ok || roleName == "" {
+		return "", nil, errors.New("missing role")
 	}
-	b.Logger().Debug("found kratos session cookie", "kratos_session_cookie", kratosSessionCookie)
 
-	client, err := b.getKratosClient(ctx, req.Storage)
+	role, err := b.readRole(ctx, req.Storage, roleName)
 	if err != nil {
-		return nil, errors.Wrap(err, "could not get Kratos client")
+		return "", nil, errors.Wrap(err, "failed to read role")
+	}
+
+	if role == nil {
+		return "", nil, errors.Errorf("role %q does not exist", roleName)
 	}
 
-	session, _, err := b.validateSessionCookie(ctx, client, kratosSessionCookie)
+	return roleName, role, nil
+}
+
+// getKratosSession returns the Kratos session from the request.
+func (b *OryAuthBackend) getKratosSession(
+	ctx context.Context,
+	req *logical.Request,
+	data *framework.FieldData,
+	config *Config,
+) (*kratos.Session, error) {
+	cookieVal, hasCookie := data.GetOk("kratos_session_cookie")
+	tokenVal, hasToken := data.GetOk("kratos_session_token")
+
+	switch {
+	case hasCookie && hasToken:
+		return nil, errBadRequest(errors.New("only one of kratos_session_cookie or kratos_session_token may be provided"))
+	case !hasCookie && !hasToken:
+		return nil, errBadRequest(errors.New("kratos_session_cookie or kratos_session_token is required"))
+	}
+
+	validator, err := b.getSessionValidator(ctx, req.Storage, config)
 	if err != nil {
-		return nil, errors.Wrap(err, "could not validate kratos session cookie")
+		return nil, err
+	}
+
+	var session *kratos.Session
+	if hasCookie {
+		kratosSessionCookie, ok := cookieVal.(string)
+		if !ok || kratosSessionCookie == "" {
+			return nil, errBadRequest(errors.New("missing kratos_session_cookie"))
+		}
+		b.Logger().Debug("found kratos session cookie", "kratos_session_cookie", kratosSessionCookie)
+
+		kratosSessionCookie = normalizeSessionCookie(config, kratosSessionCookie)
+
+		session, _, err = b.validateSessionCredential(ctx, validator, config, kratosSessionCookie, "")
+		if err != nil {
+			return nil, errors.Wrap(err, "could not validate kratos session cookie")
+		}
+	} else {
+		kratosSessionToken, ok := tokenVal.(string)
+		if !ok || kratosSessionToken == "" {
+			return nil, errBadRequest(errors.New("missing kratos_session_token"))
+		}
+		b.Logger().Debug("found kratos session token")
+
+		session, _, err = b.validateSessionCredential(ctx, validator, config, "", kratosSessionToken)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not validate kratos session token")
+		}
 	}
 
 	b.Logger().Debug("found kratos session", "session", session)
@@ -258,6 +542,10 @@ func (b *OryAuthBackend) getSubject(session *kratos.Session) (string, error) {
 		return "", errors.New("session is nil")
 	}
 
+	if session.Identity.Id == "" {
+		return "", errors.New("session has no identity")
+	}
+
 	return session.Identity.Id, nil
 }
 
@@ -293,40 +581,56 @@ func (b *OryAuthBackend) checkRelation(
 		return false, errors.Wrap(err, "failed to get keto client")
 	}
 
-	res, err := ketoClient.CheckServiceClient.Check(
-		ctx,
-		&keto.CheckRequest{
-			Namespace: namespace,
-			Object:    object,
-			Relation:  relation,
-			Subject:   keto.NewSubjectID(subject),
-		},
-	)
+	ctx, cancel := context.WithTimeout(ctx, ketoClient.readTimeout)
+	defer cancel()
+
+	allowed, err := ketoClient.checker.check(ctx, namespace, object, relation, subject)
 	if err != nil {
 		return false, errors.Wrap(err, "failed keto check")
 	}
 
-	return res.GetAllowed(), nil
+	return allowed, nil
 }
 
-// validateSessionCookie validates the session cookie by making a request to the Kratos API.
-func (b *OryAuthBackend) validateSessionCookie(
+// validateSessionCredential validates the session cookie or token, exactly one of which is set, by making a
+// request to the Kratos API.
+func (b *OryAuthBackend) validateSessionCredential(
 	ctx context.Context,
-	client *kratos.APIClient,
+	validator sessionValidator,
+	config *Config,
 	kratosSessionCookie string,
+	kratosSessionToken string,
 ) (*kratos.Session, int, error) {
-	session, res, err := client.V0alpha2Api.ToSessionExecute(
-		kratos.V0alpha2ApiApiToSessionRequest{}.Cookie(kratosSessionCookie),
-	)
+	var session *kratos.Session
+	res, err := b.callKratos(ctx, config, "toSession", func(ctx context.Context) (*http.Response, error) {
+		var res *http.Response
+		var err error
+		session, res, err = validator.toSession(ctx, kratosSessionCookie, kratosSessionToken)
+
+		return res, err
+	})
 	if err != nil {
 		b.Logger().Error("error while trying to get kratos session", "err", err)
-		return nil, http.StatusInternalServerError, errors.Wrap(err, "failed to get kratos session")
+		status, err := sessionValidationError(res, err)
+		return nil, status, err
 	}
 
 	if res.StatusCode != http.StatusOK {
 		b.Logger().Debug("status was not 200", "status", res.StatusCode)
-		return nil, res.StatusCode, errors.Wrap(err, "failed to get kratos session")
+		return nil, res.StatusCode, errSessionInvalid(errors.Errorf("failed to get kratos session: status %d", res.StatusCode))
 	}
 
 	return session, http.StatusOK, nil
 }
+
+// sessionValidationError classifies a failed session validation: Kratos being unavailable is an upstream
+// error, while Kratos rejecting the cookie or token means the session is invalid.
+func sessionValidationError(res *http.Response, err error) (int, error) {
+	if isKratosUnavailable(res, err) {
+		return http.StatusServiceUnavailable, errUpstreamUnavailable(errors.Wrap(err, "failed to get kratos session"))
+	}
+
+	return res.StatusCode, errSessionInvalid(
+		errors.Wrapf(err, "kratos rejected the session: status %d", res.StatusCode),
+	)
+}
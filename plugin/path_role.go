@@ -0,0 +1,302 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/pkg/errors"
+)
+
+const (
+	// roleSynopsis is used to provide a short summary of the role path.
+	roleSynopsis = `Manages the roles that can be used to log in.`
+
+	// roleDescription is used to provide a detailed description of the role path.
+	roleDescription = `
+A role restricts the Keto namespaces, objects and relations that can be
+requested at login, and sets the policies, TTLs and type of the issued token.
+Namespaces, object patterns and relations may contain globs (e.g. 'files/*').
+`
+
+	// roleListSynopsis is used to provide a short summary of the role list path.
+	roleListSynopsis = `Lists the configured roles.`
+
+	// roleListDescription is used to provide a detailed description of the role list path.
+	roleListDescription = `This endpoint lists the names of the configured roles.`
+)
+
+var roleFields map[string]*framework.FieldSchema = map[string]*framework.FieldSchema{
+	"name": {
+		Type:        framework.TypeString,
+		Description: "The name of the role",
+		Required:    true,
+	},
+	"allowed_namespaces": {
+		Type:        framework.TypeCommaStringSlice,
+		Description: "Keto namespaces that may be requested with this role (globs allowed)",
+		Required:    true,
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name:      "Allowed Namespaces",
+			Sensitive: false,
+		},
+	},
+	"allowed_object_patterns": {
+		Type:        framework.TypeCommaStringSlice,
+		Description: "Keto objects that may be requested with this role (globs allowed)",
+		Required:    true,
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name:      "Allowed Object Patterns",
+			Sensitive: false,
+		},
+	},
+	"allowed_relations": {
+		Type:        framework.TypeCommaStringSlice,
+		Description: "Keto relations that may be requested with this role (globs allowed)",
+		Required:    true,
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name:      "Allowed Relations",
+			Sensitive: false,
+		},
+	},
+	"token_policies": {
+		Type:        framework.TypeCommaStringSlice,
+		Description: "Policies attached to tokens issued with this role, in addition to the namespace_relation policy",
+		Required:    false,
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name:      "Token Policies",
+			Sensitive: false,
+		},
+	},
+	"ttl_seconds": {
+		Type:        framework.TypeDurationSecond,
+		Description: "The TTL of tokens issued with this role (defaults to the config TTL)",
+		Required:    false,
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name:      "TTL Seconds",
+			Sensitive: false,
+		},
+	},
+	"max_ttl_seconds": {
+		Type:        framework.TypeDurationSecond,
+		Description: "The maximum TTL of tokens issued with this role (defaults to the config max TTL)",
+		Required:    false,
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name:      "Max TTL Seconds",
+			Sensitive: false,
+		},
+	},
+	"token_type": {
+		Type:        framework.TypeString,
+		Description: "The type of tokens issued with this role (default, service or batch)",
+		Required:    false,
+		Default:     "default",
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name:      "Token Type",
+			Sensitive: false,
+		},
+	},
+}
+
+// NewPathRole creates the paths for managing roles.
+func NewPathRole(b *OryAuthBackend) []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: "role/?$",
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.ListOperation: b.listRolesHandler,
+			},
+			HelpSynopsis:    roleListSynopsis,
+			HelpDescription: roleListDescription,
+		},
+		{
+			Pattern:        "role/" + framework.GenericNameRegex("name"),
+			Fields:         roleFields,
+			ExistenceCheck: b.roleExistenceCheck,
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.CreateOperation: b.writeRoleHandler,
+				logical.ReadOperation:   b.readRoleHandler,
+				logical.UpdateOperation: b.writeRoleHandler,
+				logical.DeleteOperation: b.deleteRoleHandler,
+			},
+			HelpSynopsis:    roleSynopsis,
+			HelpDescription: roleDescription,
+		},
+	}
+}
+
+// roleExistenceCheck checks whether the role already exists.
+func (b *OryAuthBackend) roleExistenceCheck(
+	ctx context.Context,
+	req *logical.Request,
+	data *framework.FieldData,
+) (bool, error) {
+	role, err := b.readRole(ctx, req.Storage, data.Get("name").(string))
+	if err != nil {
+		return false, err
+	}
+
+	return role != nil, nil
+}
+
+// listRolesHandler lists the roles in the storage.
+func (b *OryAuthBackend) listRolesHandler(
+	ctx context.Context,
+	req *logical.Request,
+	data *framework.FieldData,
+) (*logical.Response, error) {
+	roles, err := req.Storage.List(ctx, rolePrefix)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list roles")
+	}
+
+	return logical.ListResponse(roles), nil
+}
+
+// readRoleHandler reads the role from the storage.
+func (b *OryAuthBackend) readRoleHandler(
+	ctx context.Context,
+	req *logical.Request,
+	data *framework.FieldData,
+) (*logical.Response, error) {
+	role, err := b.readRole(ctx, req.Storage, data.Get("name").(string))
+	if err != nil {
+		return nil, err
+	}
+
+	if role == nil {
+		return nil, nil
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"allowed_namespaces":      role.AllowedNamespaces,
+			"allowed_object_patterns": role.AllowedObjectPatterns,
+			"allowed_relations":       role.AllowedRelations,
+			"token_policies":          role.TokenPolicies,
+			"ttl_seconds":             role.TTLSeconds,
+			"max_ttl_seconds":         role.MaxTTLSeconds,
+			"token_type":              role.TokenType,
+		},
+	}, nil
+}
+
+// writeRoleHandler creates or updates the role in the storage.
+func (b *OryAuthBackend) writeRoleHandler(
+	ctx context.Context,
+	req *logical.Request,
+	data *framework.FieldData,
+) (*logical.Response, error) {
+	name := data.Get("name").(string)
+
+	role, err := b.readRole(ctx, req.Storage, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if role == nil {
+		role = &Role{}
+	}
+
+	err = b.decodeRoleFieldData(role, data)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode role field data")
+	}
+
+	err = role.validate()
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	err = b.setRole(ctx, req.Storage, name, role)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to write role")
+	}
+
+	return nil, nil
+}
+
+// deleteRoleHandler deletes the role from the storage.
+func (b *OryAuthBackend) deleteRoleHandler(
+	ctx context.Context,
+	req *logical.Request,
+	data *framework.FieldData,
+) (*logical.Response, error) {
+	return nil, req.Storage.Delete(ctx, rolePrefix+data.Get("name").(string))
+}
+
+// decodeRoleFieldData decodes the incoming role field data and sets the values in the role struct
+func (b *OryAuthBackend) decodeRoleFieldData(role *Role, data *framework.FieldData) error {
+	if role == nil {
+		return errors.New("nil role used to decode field data")
+	}
+	if data == nil {
+		return errors.New("nil data used to decode field data")
+	}
+
+	if val, ok := data.GetOk("allowed_namespaces"); ok {
+		b.Logger().Debug("got role value", "allowed_namespaces", val)
+
+		role.AllowedNamespaces, ok = val.([]string)
+		if !ok {
+			b.Logger().Error(fmt.Sprintf("allowed_namespaces was a %T, expected a []string", val))
+		}
+	}
+
+	if val, ok := data.GetOk("allowed_object_patterns"); ok {
+		b.Logger().Debug("got role value", "allowed_object_patterns", val)
+
+		role.AllowedObjectPatterns, ok = val.([]string)
+		if !ok {
+			b.Logger().Error(fmt.Sprintf("allowed_object_patterns was a %T, expected a []string", val))
+		}
+	}
+
+	if val, ok := data.GetOk("allowed_relations"); ok {
+		b.Logger().Debug("got role value", "allowed_relations", val)
+
+		role.AllowedRelations, ok = val.([]string)
+		if !ok {
+			b.Logger().Error(fmt.Sprintf("allowed_relations was a %T, expected a []string", val))
+		}
+	}
+
+	if val, ok := data.GetOk("token_policies"); ok {
+		b.Logger().Debug("got role value", "token_policies", val)
+
+		role.TokenPolicies, ok = val.([]string)
+		if !ok {
+			b.Logger().Error(fmt.Sprintf("token_policies was a %T, expected a []string", val))
+		}
+	}
+
+	if val, ok := data.GetOk("ttl_seconds"); ok {
+		b.Logger().Debug("got role value", "ttl_seconds", val)
+
+		role.TTLSeconds, ok = val.(int)
+		if !ok {
+			b.Logger().Error(fmt.Sprintf("ttl_seconds was a %T, expected int", val))
+		}
+	}
+
+	if val, ok := data.GetOk("max_ttl_seconds"); ok {
+		b.Logger().Debug("got role value", "max_ttl_seconds", val)
+
+		role.MaxTTLSeconds, ok = val.(int)
+		if !ok {
+			b.Logger().Error(fmt.Sprintf("max_ttl_seconds was a %T, expected int", val))
+		}
+	}
+
+	if val, ok := data.GetOk("token_type"); ok {
+		b.Logger().Debug("got role value", "token_type", val)
+
+		role.TokenType, ok = val.(string)
+		if !ok {
+			b.Logger().Error(fmt.Sprintf("token_type was a %T, expected a string", val))
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,274 @@
+package plugin
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	kratos "github.com/ory/kratos-client-go"
+	"github.com/pkg/errors"
+)
+
+const (
+	// loginMethodKratos is the login method for Kratos session cookies and tokens.
+	loginMethodKratos = "kratos"
+
+	// loginMethodHydra is the login method for Hydra OAuth2 access tokens.
+	loginMethodHydra = "hydra"
+
+	// loginMethodJWT is the login method for locally verified JWTs, such as Kratos tokenized
+	// sessions and Oathkeeper id_tokens.
+	loginMethodJWT = "jwt"
+)
+
+// loginCredentialFields are the login fields that carry a credential, exactly one of which is required.
+var loginCredentialFields = []string{
+	"kratos_session_cookie",
+	"kratos_session_token",
+	"access_token",
+	"jwt",
+}
+
+// loginPrincipal is the caller authenticated by one of the supported login methods.
+type loginPrincipal struct {
+	// method is the login method used to authenticate the caller.
+	method string
+
+	// subject is the Keto subject ID of the caller.
+	subject string
+
+	// expiresAt is when the credential used to log in expires, if known.
+	expiresAt *time.Time
+
+	// expiryCapsTTL determines whether expiresAt always caps the token TTL, regardless of
+	// `use_session_expiry_ttl`.
+	expiryCapsTTL bool
+
+	// session is the Kratos session of the caller, if the caller logged in with Kratos.
+	session *kratos.Session
+
+	// credentialField is the login field that carried the credential.
+	credentialField string
+
+	// credential is the credential used to log in, kept to re-authenticate the caller on renewal.
+	credential string
+}
+
+// getPrincipal authenticates the caller using whichever credential was supplied in the request.
+func (b *OryAuthBackend) getPrincipal(
+	ctx context.Context,
+	req *logical.Request,
+	data *framework.FieldData,
+	config *Config,
+) (*loginPrincipal, error) {
+	b.Logger().Debug("getting principal from data")
+
+	var provided []string
+	for _, field := range loginCredentialFields {
+		if _, ok := data.GetOk(field); ok {
+			provided = append(provided, field)
+		}
+	}
+
+	if len(provided) != 1 {
+		return nil, errBadRequest(errors.Errorf(
+			"exactly one of %s is required",
+			strings.Join(loginCredentialFields, ", "),
+		))
+	}
+
+	var principal *loginPrincipal
+	var err error
+
+	switch provided[0] {
+	case "access_token":
+		principal, err = b.getHydraPrincipal(ctx, req, data, config)
+	case "jwt":
+		principal, err = b.getJWTPrincipal(ctx, req, data, config)
+	default:
+		principal, err = b.getKratosPrincipal(ctx, req, data, config)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	principal.credentialField = provided[0]
+	principal.credential, _ = data.Get(provided[0]).(string)
+
+	return principal, nil
+}
+
+// getKratosPrincipal authenticates the caller with a Kratos session cookie or token.
+func (b *OryAuthBackend) getKratosPrincipal(
+	ctx context.Context,
+	req *logical.Request,
+	data *framework.FieldData,
+	config *Config,
+) (*loginPrincipal, error) {
+	session, err := b.getKratosSession(ctx, req, data, config)
+	if err != nil {
+		return nil, err
+	}
+
+	err = validateSession(session, time.Duration(config.SessionClockSkew)*time.Second, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	subject, err := b.getSubject(session)
+	if err != nil {
+		return nil, err
+	}
+
+	return &loginPrincipal{
+		method:    loginMethodKratos,
+		subject:   subject,
+		expiresAt: session.ExpiresAt,
+		session:   session,
+	}, nil
+}
+
+// getHydraPrincipal authenticates the caller with a Hydra OAuth2 access token.
+func (b *OryAuthBackend) getHydraPrincipal(
+	ctx context.Context,
+	req *logical.Request,
+	data *framework.FieldData,
+	config *Config,
+) (*loginPrincipal, error) {
+	accessToken, ok := data.Get("access_token").(string)
+	if !ok || accessToken == "" {
+		return nil, errBadRequest(errors.New("missing access_token"))
+	}
+	b.Logger().Debug("found hydra access token")
+
+	client, err := b.getHydraClient(ctx, req.Storage)
+	if err != nil {
+		return nil, errBadRequest(errors.Wrap(err, "could not get Hydra client"))
+	}
+
+	introspection, err := b.introspectAccessToken(ctx, client, accessToken)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not validate access token")
+	}
+
+	err = b.validateIntrospection(introspection, config.HydraRequiredScopes)
+	if err != nil {
+		return nil, err
+	}
+
+	subject, err := b.getHydraSubject(introspection)
+	if err != nil {
+		return nil, err
+	}
+
+	var expiresAt *time.Time
+	if introspection.ExpiresAt != 0 {
+		exp := time.Unix(introspection.ExpiresAt, 0)
+		expiresAt = &exp
+	}
+
+	return &loginPrincipal{
+		method:        loginMethodHydra,
+		subject:       subject,
+		expiresAt:     expiresAt,
+		expiryCapsTTL: true,
+	}, nil
+}
+
+// getJWTPrincipal authenticates the caller with a JWT verified against the configured JWKS.
+func (b *OryAuthBackend) getJWTPrincipal(
+	ctx context.Context,
+	req *logical.Request,
+	data *framework.FieldData,
+	config *Config,
+) (*loginPrincipal, error) {
+	rawToken, ok := data.Get("jwt").(string)
+	if !ok || rawToken == "" {
+		return nil, errBadRequest(errors.New("missing jwt"))
+	}
+	b.Logger().Debug("found jwt")
+
+	claims, allClaims, err := b.validateJWT(ctx, req.Storage, config, rawToken)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not validate jwt")
+	}
+
+	subject, err := b.getJWTSubject(config, allClaims)
+	if err != nil {
+		return nil, err
+	}
+
+	expiresAt := claims.Expiry.Time()
+
+	return &loginPrincipal{
+		method:        loginMethodJWT,
+		subject:       subject,
+		expiresAt:     &expiresAt,
+		expiryCapsTTL: true,
+	}, nil
+}
+
+// checkPrincipal checks that the principal meets the identity and session requirements of the config for
+// the tuples, and returns how long its session remains fresh, or zero if no maximum session age applies.
+//
+// It is called on both login and renewal, so that a requirement cannot be enforced on one but not the other.
+func (b *OryAuthBackend) checkPrincipal(
+	config *Config,
+	principal *loginPrincipal,
+	tuples []relationTuple,
+) (time.Duration, error) {
+	err := b.checkSchemaID(config, principal)
+	if err != nil {
+		return 0, err
+	}
+
+	err = b.checkIdentity(config, principal)
+	if err != nil {
+		return 0, err
+	}
+
+	err = b.checkAAL(config, principal, tuples)
+	if err != nil {
+		return 0, err
+	}
+
+	return b.checkSessionAge(config, principal, tuples)
+}
+
+// tokenTTLs returns the TTL and max TTL of the token issued to the principal, capped by the expiry of its
+// credential and by how long its session remains fresh.
+func (p *loginPrincipal) tokenTTLs(config *Config, role *Role, freshFor time.Duration) (time.Duration, time.Duration) {
+	ttl, maxTTL := role.getTTLs(config)
+	ttl = p.capTTL(ttl, config.UseSessionExpiryTTL)
+
+	// the token must not outlive the session's freshness window
+	if freshFor > 0 && freshFor < ttl {
+		ttl = freshFor
+	}
+
+	return ttl, maxTTL
+}
+
+// capTTL caps the TTL of the token issued to the principal by the expiry of its credential.
+func (p *loginPrincipal) capTTL(ttl time.Duration, useExpiryTTL bool) time.Duration {
+	if p.expiresAt == nil {
+		return ttl
+	}
+
+	untilExpiry := time.Until(*p.expiresAt)
+
+	// a credential accepted within the clock skew tolerance may have just expired, and a TTL of zero
+	// would give the token the default TTL instead
+	if untilExpiry < time.Second {
+		untilExpiry = time.Second
+	}
+
+	if useExpiryTTL || (p.expiryCapsTTL && untilExpiry < ttl) {
+		return untilExpiry
+	}
+
+	return ttl
+}
@@ -0,0 +1,179 @@
+package plugin
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// oryNetworkDomain is the domain under which Ory Network projects are served.
+	oryNetworkDomain = ".projects.oryapis.com"
+
+	// oryNetworkCookiePrefix is the prefix of the session cookie of an Ory Network project, which is
+	// followed by the project slug without hyphens.
+	oryNetworkCookiePrefix = "ory_session_"
+
+	// defaultSessionCookieName is the name of the session cookie of a self-hosted Kratos.
+	defaultSessionCookieName = "ory_kratos_session"
+)
+
+// projectSlugRegex matches valid Ory Network project slugs.
+var projectSlugRegex = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*[a-z0-9]$`)
+
+// validateProjectSlug checks that the Ory Network project slug is well-formed.
+func validateProjectSlug(slug string) error {
+	if slug == "" || projectSlugRegex.MatchString(slug) {
+		return nil
+	}
+
+	return errors.Errorf("invalid ory_project_slug %q, expected lowercase letters, digits and hyphens", slug)
+}
+
+// validateProjectURL checks that the Ory Network project URL is an absolute HTTPS URL.
+func validateProjectURL(projectURL string) error {
+	if projectURL == "" {
+		return nil
+	}
+
+	u, err := url.Parse(projectURL)
+	if err != nil {
+		return errors.Wrap(err, "invalid ory_project_url")
+	}
+
+	if u.Scheme != "https" || u.Host == "" {
+		return errors.Errorf("invalid ory_project_url %q, expected an https URL", projectURL)
+	}
+
+	return nil
+}
+
+// projectURL returns the URL of the Ory Network project, or an empty string if none is configured.
+func (c *Config) projectURL() string {
+	if c.OryProjectURL != "" {
+		return strings.TrimSuffix(c.OryProjectURL, "/")
+	}
+
+	if c.OryProjectSlug != "" {
+		return "https://" + c.OryProjectSlug + oryNetworkDomain
+	}
+
+	return ""
+}
+
+// projectSlug returns the slug of the Ory Network project, derived from the project URL if it is not
+// configured, or an empty string if it is unknown.
+func (c *Config) projectSlug() string {
+	if c.OryProjectSlug != "" {
+		return c.OryProjectSlug
+	}
+
+	u, err := url.Parse(c.OryProjectURL)
+	if err != nil || !strings.HasSuffix(u.Hostname(), oryNetworkDomain) {
+		return ""
+	}
+
+	return strings.TrimSuffix(u.Hostname(), oryNetworkDomain)
+}
+
+// kratosURL returns the URL of the Kratos public API, falling back to the Ory Network project.
+func (c *Config) kratosURL() string {
+	if c.KratosURL != "" {
+		return c.KratosURL
+	}
+
+	return c.projectURL()
+}
+
+// kratosAdminURL returns the URL of the Kratos admin API, falling back to the Ory Network project.
+func (c *Config) kratosAdminURL() string {
+	if c.KratosAdminURL != "" {
+		return c.KratosAdminURL
+	}
+
+	return c.projectURL()
+}
+
+// kratosAdminAPIKey returns the API key for the Kratos admin API, falling back to the Ory Network API key.
+func (c *Config) kratosAdminAPIKey() string {
+	if c.KratosAdminAPIKey != "" {
+		return c.KratosAdminAPIKey
+	}
+
+	return c.OryAPIKey
+}
+
+// ketoReadTarget returns the gRPC target of the Keto read API and whether it must be dialled with TLS,
+// which it is unless plaintext is explicitly enabled.
+func (c *Config) ketoReadTarget() (string, bool) {
+	keto := c.ketoConfig()
+	if keto.ReadAddr != "" {
+		return keto.ReadAddr, !keto.Plaintext
+	}
+
+	return c.projectKetoTarget()
+}
+
+// projectKetoTarget returns the gRPC target of the Keto APIs of the Ory Network project, which serves
+// gRPC over TLS on the HTTPS port of the project URL.
+func (c *Config) projectKetoTarget() (string, bool) {
+	projectURL := c.projectURL()
+	if projectURL == "" {
+		return "", false
+	}
+
+	u, err := url.Parse(projectURL)
+	if err != nil {
+		return "", false
+	}
+
+	port := u.Port()
+	if port == "" {
+		port = "443"
+	}
+
+	return net.JoinHostPort(u.Hostname(), port), true
+}
+
+// sessionCookieName returns the name of the Kratos session cookie, which is `ory_session_<slug>` for
+// Ory Network projects, with the hyphens removed from the slug.
+func sessionCookieName(config *Config) string {
+	slug := config.projectSlug()
+	if slug == "" {
+		return defaultSessionCookieName
+	}
+
+	return oryNetworkCookiePrefix + strings.ReplaceAll(slug, "-", "")
+}
+
+// normalizeSessionCookie returns the session cookie as a cookie header value.
+//
+// A bare session cookie value is prefixed with the name of the session cookie, so that clients do not
+// have to know the cookie naming of the project. Kratos cookie values are base64 encoded and may end in
+// padding, so only an `=` before the padding marks a full cookie.
+func normalizeSessionCookie(config *Config, cookie string) string {
+	if strings.Contains(strings.TrimRight(cookie, "="), "=") {
+		return cookie
+	}
+
+	return sessionCookieName(config) + "=" + cookie
+}
+
+// ketoAPIKeyCredentials sends an Ory API key as a bearer token with every Keto RPC.
+type ketoAPIKeyCredentials struct {
+	apiKey string
+}
+
+// GetRequestMetadata returns the authorization header of the RPC.
+func (c ketoAPIKeyCredentials) GetRequestMetadata(context.Context, ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + c.apiKey}, nil
+}
+
+// RequireTransportSecurity reports that the API key must only be sent over TLS.
+func (c ketoAPIKeyCredentials) RequireTransportSecurity() bool {
+	return true
+}
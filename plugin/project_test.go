@@ -0,0 +1,52 @@
+package plugin
+
+import "testing"
+
+func TestNormalizeSessionCookie(t *testing.T) {
+	tests := []struct {
+		name   string
+		config *Config
+		cookie string
+		want   string
+	}{
+		{
+			name:   "bare value",
+			config: &Config{},
+			cookie: "MTY3Mzk",
+			want:   "ory_kratos_session=MTY3Mzk",
+		},
+		{
+			name:   "bare value with padding",
+			config: &Config{},
+			cookie: "MTY3Mzk0==",
+			want:   "ory_kratos_session=MTY3Mzk0==",
+		},
+		{
+			name:   "full cookie with padding",
+			config: &Config{},
+			cookie: "ory_kratos_session=MTY3Mzk0==",
+			want:   "ory_kratos_session=MTY3Mzk0==",
+		},
+		{
+			name:   "bare value for an ory network project",
+			config: &Config{OryProjectSlug: "happy-lamport-1234"},
+			cookie: "MTY3Mzk0==",
+			want:   "ory_session_happylamport1234=MTY3Mzk0==",
+		},
+		{
+			name:   "several cookies",
+			config: &Config{},
+			cookie: "csrf_token=abc=; ory_kratos_session=MTY3Mzk0==",
+			want:   "csrf_token=abc=; ory_kratos_session=MTY3Mzk0==",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := normalizeSessionCookie(tt.config, tt.cookie)
+			if got != tt.want {
+				t.Errorf("normalizeSessionCookie() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
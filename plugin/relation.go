@@ -0,0 +1,296 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	keto "github.com/ory/keto/proto/ory/keto/relation_tuples/v1alpha2"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+)
+
+const (
+	// ketoTransportGRPC checks relation tuples with the gRPC API of Keto.
+	ketoTransportGRPC = "grpc"
+
+	// ketoTransportREST checks relation tuples with the REST API of Keto.
+	ketoTransportREST = "rest"
+
+	// ketoCheckPath is the path of the Keto REST endpoint checking a relation tuple.
+	ketoCheckPath = "/relation-tuples/check"
+
+	// ketoRelationTuplesPath is the path of the Keto REST endpoint listing relation tuples.
+	ketoRelationTuplesPath = "/relation-tuples"
+
+	// ketoAlivePath is the path of the Keto REST endpoint reporting whether Keto is alive.
+	ketoAlivePath = "/health/alive"
+
+	// maxKetoResponseSize bounds the size of a response read from the Keto REST API.
+	maxKetoResponseSize = 1 << 20
+)
+
+// relationChecker checks and lists relation tuples with one Keto transport.
+type relationChecker interface {
+	// check reports whether the subject has the relation to the object in the namespace.
+	check(ctx context.Context, namespace, object, relation, subject string) (bool, error)
+
+	// listObjects returns a page of the objects in the namespace that the subject has the relation to
+	// directly, and the token of the next page, which is empty on the last page.
+	listObjects(
+		ctx context.Context,
+		namespace, relation string,
+		subject ketoSubject,
+		pageToken string,
+		pageSize int,
+	) ([]string, string, error)
+
+	// healthy returns an error if the Keto read API cannot be reached.
+	healthy(ctx context.Context) error
+}
+
+// ketoSubject is the subject of a relation tuple: a subject ID, or the subject set of a tuple if set is not nil.
+type ketoSubject struct {
+	id  string
+	set *relationTuple
+}
+
+// proto returns the subject as a Keto gRPC subject.
+func (s ketoSubject) proto() *keto.Subject {
+	if s.set != nil {
+		return keto.NewSubjectSet(s.set.Namespace, s.set.Object, s.set.Relation)
+	}
+
+	return keto.NewSubjectID(s.id)
+}
+
+// setQuery sets the subject in the query of a Keto REST request.
+func (s ketoSubject) setQuery(query url.Values) {
+	if s.set != nil {
+		query.Set("subject_set.namespace", s.set.Namespace)
+		query.Set("subject_set.object", s.set.Object)
+		query.Set("subject_set.relation", s.set.Relation)
+
+		return
+	}
+
+	query.Set("subject_id", s.id)
+}
+
+// validateKetoTransportName checks that the Keto transport can be used.
+func validateKetoTransportName(transport string) error {
+	switch transport {
+	case "", ketoTransportGRPC, ketoTransportREST:
+		return nil
+	default:
+		return errors.Errorf("invalid keto_transport %q, expected one of grpc or rest", transport)
+	}
+}
+
+// grpcRelationChecker checks relation tuples with the gRPC CheckService of Keto.
+type grpcRelationChecker struct {
+	conn   *grpc.ClientConn
+	client keto.CheckServiceClient
+	reader keto.ReadServiceClient
+}
+
+// check reports whether the subject has the relation to the object in the namespace.
+func (c *grpcRelationChecker) check(ctx context.Context, namespace, object, relation, subject string) (bool, error) {
+	res, err := c.client.Check(
+		ctx,
+		&keto.CheckRequest{
+			Namespace: namespace,
+			Object:    object,
+			Relation:  relation,
+			Subject:   keto.NewSubjectID(subject),
+		},
+	)
+	if err != nil {
+		return false, err
+	}
+
+	return res.GetAllowed(), nil
+}
+
+// listObjects returns a page of the objects in the namespace that the subject has the relation to directly.
+func (c *grpcRelationChecker) listObjects(
+	ctx context.Context,
+	namespace, relation string,
+	subject ketoSubject,
+	pageToken string,
+	pageSize int,
+) ([]string, string, error) {
+	res, err := c.reader.ListRelationTuples(
+		ctx,
+		&keto.ListRelationTuplesRequest{
+			// the query is used rather than the relation query for Keto versions before v0.10
+			Query: &keto.ListRelationTuplesRequest_Query{
+				Namespace: namespace,
+				Relation:  relation,
+				Subject:   subject.proto(),
+			},
+			PageToken: pageToken,
+			PageSize:  int32(pageSize),
+		},
+	)
+	if err != nil {
+		return nil, "", err
+	}
+
+	objects := make([]string, 0, len(res.GetRelationTuples()))
+	for _, tuple := range res.GetRelationTuples() {
+		objects = append(objects, tuple.GetObject())
+	}
+
+	return objects, res.GetNextPageToken(), nil
+}
+
+// healthy returns an error if the gRPC connection has failed.
+func (c *grpcRelationChecker) healthy(context.Context) error {
+	connState := c.conn.GetState()
+	if connState != connectivity.Ready && connState != connectivity.Idle {
+		return errors.Errorf("read api is %v", connState)
+	}
+
+	return nil
+}
+
+// restRelationChecker checks relation tuples with the REST API of Keto. Requests are made directly, as
+// the keto-client-go version the plugin used to depend on predates relation tuples.
+type restRelationChecker struct {
+	baseURL    string
+	httpClient *http.Client
+
+	// apiKey is sent as a bearer token with every request, if set.
+	apiKey string
+}
+
+// check reports whether the subject has the relation to the object in the namespace.
+//
+// Keto answers a check with 200 if the subject has the relation, and with 403 if it does not.
+func (c *restRelationChecker) check(ctx context.Context, namespace, object, relation, subject string) (bool, error) {
+	query := url.Values{}
+	query.Set("namespace", namespace)
+	query.Set("object", object)
+	query.Set("relation", relation)
+	query.Set("subject_id", subject)
+
+	res, err := c.get(ctx, ketoCheckPath+"?"+query.Encode())
+	if err != nil {
+		return false, err
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK:
+	case http.StatusForbidden:
+		return false, nil
+	default:
+		return false, errors.Errorf("check request failed: %s", res.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(res.Body, maxKetoResponseSize))
+	if err != nil {
+		return false, errors.Wrap(err, "failed to read check response")
+	}
+
+	var result struct {
+		Allowed bool `json:"allowed"`
+	}
+
+	err = json.Unmarshal(body, &result)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to decode check response")
+	}
+
+	return result.Allowed, nil
+}
+
+// listObjects returns a page of the objects in the namespace that the subject has the relation to directly.
+func (c *restRelationChecker) listObjects(
+	ctx context.Context,
+	namespace, relation string,
+	subject ketoSubject,
+	pageToken string,
+	pageSize int,
+) ([]string, string, error) {
+	query := url.Values{}
+	query.Set("namespace", namespace)
+	query.Set("relation", relation)
+	subject.setQuery(query)
+	query.Set("page_size", strconv.Itoa(pageSize))
+
+	if pageToken != "" {
+		query.Set("page_token", pageToken)
+	}
+
+	res, err := c.get(ctx, ketoRelationTuplesPath+"?"+query.Encode())
+	if err != nil {
+		return nil, "", err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, "", errors.Errorf("list request failed: %s", res.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(res.Body, maxKetoResponseSize))
+	if err != nil {
+		return nil, "", errors.Wrap(err, "failed to read list response")
+	}
+
+	var result struct {
+		RelationTuples []struct {
+			Object string `json:"object"`
+		} `json:"relation_tuples"`
+		NextPageToken string `json:"next_page_token"`
+	}
+
+	err = json.Unmarshal(body, &result)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "failed to decode list response")
+	}
+
+	objects := make([]string, 0, len(result.RelationTuples))
+	for _, tuple := range result.RelationTuples {
+		objects = append(objects, tuple.Object)
+	}
+
+	return objects, result.NextPageToken, nil
+}
+
+// healthy returns an error if the Keto REST API is not alive.
+func (c *restRelationChecker) healthy(ctx context.Context) error {
+	res, err := c.get(ctx, ketoAlivePath)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return errors.Errorf("read api is not alive: %s", res.Status)
+	}
+
+	return nil
+}
+
+// get makes a GET request to the path of the Keto REST API.
+func (c *restRelationChecker) get(ctx context.Context, path string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(c.baseURL, "/")+path, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create keto request")
+	}
+
+	req.Header.Set("Accept", "application/json")
+
+	if c.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+
+	return c.httpClient.Do(req)
+}
@@ -0,0 +1,450 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"testing"
+
+	keto "github.com/ory/keto/proto/ory/keto/relation_tuples/v1alpha2"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// unavailableNamespace is a namespace that the fake Keto fails every request for.
+const unavailableNamespace = "unavailable"
+
+// fakeTuple is a relation tuple with its subject, as a subject ID or in `namespace:object#relation` notation.
+type fakeTuple struct {
+	relationTuple
+	subject string
+}
+
+// fakeKeto is an in-memory Keto holding relation tuples, served over both transports by the stubs below.
+type fakeKeto struct {
+	tuples []fakeTuple
+}
+
+// check reports whether the tuple exists.
+func (k *fakeKeto) check(namespace, object, relation, subject string) (bool, error) {
+	if namespace == unavailableNamespace {
+		return false, errors.New("keto is unavailable")
+	}
+
+	for _, tuple := range k.tuples {
+		if tuple == (fakeTuple{relationTuple{Namespace: namespace, Object: object, Relation: relation}, subject}) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// list returns a page of the objects of the tuples matching the query, using offsets as page tokens.
+func (k *fakeKeto) list(namespace, relation, subject, pageToken string, pageSize int) ([]string, string, error) {
+	if namespace == unavailableNamespace {
+		return nil, "", errors.New("keto is unavailable")
+	}
+
+	var objects []string
+	for _, tuple := range k.tuples {
+		if tuple.Namespace == namespace && tuple.Relation == relation && tuple.subject == subject {
+			objects = append(objects, tuple.Object)
+		}
+	}
+
+	offset := 0
+	if pageToken != "" {
+		var err error
+		offset, err = strconv.Atoi(pageToken)
+		if err != nil {
+			return nil, "", errors.Errorf("invalid page token %q", pageToken)
+		}
+	}
+
+	if offset > len(objects) {
+		offset = len(objects)
+	}
+
+	end := offset + pageSize
+	if end >= len(objects) {
+		return objects[offset:], "", nil
+	}
+
+	return objects[offset:end], strconv.Itoa(end), nil
+}
+
+// grpcKetoStub serves the fake Keto with the gRPC CheckService and ReadService.
+type grpcKetoStub struct {
+	keto.UnimplementedCheckServiceServer
+	keto.UnimplementedReadServiceServer
+
+	keto *fakeKeto
+}
+
+func (s *grpcKetoStub) Check(_ context.Context, req *keto.CheckRequest) (*keto.CheckResponse, error) {
+	allowed, err := s.keto.check(req.GetNamespace(), req.GetObject(), req.GetRelation(), req.GetSubject().GetId())
+	if err != nil {
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+
+	return &keto.CheckResponse{Allowed: allowed}, nil
+}
+
+func (s *grpcKetoStub) ListRelationTuples(
+	_ context.Context,
+	req *keto.ListRelationTuplesRequest,
+) (*keto.ListRelationTuplesResponse, error) {
+	query := req.GetQuery()
+
+	subject := query.GetSubject().GetId()
+	if set := query.GetSubject().GetSet(); set != nil {
+		subject = relationTuple{
+			Namespace: set.GetNamespace(),
+			Object:    set.GetObject(),
+			Relation:  set.GetRelation(),
+		}.String()
+	}
+
+	objects, nextPageToken, err := s.keto.list(
+		query.GetNamespace(),
+		query.GetRelation(),
+		subject,
+		req.GetPageToken(),
+		int(req.GetPageSize()),
+	)
+	if err != nil {
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+
+	res := &keto.ListRelationTuplesResponse{NextPageToken: nextPageToken}
+	for _, object := range objects {
+		res.RelationTuples = append(res.RelationTuples, &keto.RelationTuple{
+			Namespace: query.GetNamespace(),
+			Object:    object,
+			Relation:  query.GetRelation(),
+		})
+	}
+
+	return res, nil
+}
+
+// newGRPCTestChecker returns a gRPC relation checker connected to the fake Keto over an in-memory listener.
+func newGRPCTestChecker(t *testing.T, fake *fakeKeto) relationChecker {
+	t.Helper()
+
+	listener := bufconn.Listen(1 << 20)
+
+	server := grpc.NewServer()
+	stub := &grpcKetoStub{keto: fake}
+	keto.RegisterCheckServiceServer(server, stub)
+	keto.RegisterReadServiceServer(server, stub)
+
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.Dial(
+		"bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("failed to dial the keto stub: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return &grpcRelationChecker{
+		conn:   conn,
+		client: keto.NewCheckServiceClient(conn),
+		reader: keto.NewReadServiceClient(conn),
+	}
+}
+
+// newRESTTestChecker returns a REST relation checker connected to the fake Keto served over HTTP.
+func newRESTTestChecker(t *testing.T, fake *fakeKeto) relationChecker {
+	t.Helper()
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc(ketoCheckPath, func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+
+		allowed, err := fake.check(query.Get("namespace"), query.Get("object"), query.Get("relation"), query.Get("subject_id"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		// Keto answers denied checks with 403
+		if !allowed {
+			w.WriteHeader(http.StatusForbidden)
+		}
+
+		_ = json.NewEncoder(w).Encode(map[string]bool{"allowed": allowed})
+	})
+
+	mux.HandleFunc(ketoRelationTuplesPath, func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+
+		subject := query.Get("subject_id")
+		if query.Has("subject_set.namespace") {
+			subject = relationTuple{
+				Namespace: query.Get("subject_set.namespace"),
+				Object:    query.Get("subject_set.object"),
+				Relation:  query.Get("subject_set.relation"),
+			}.String()
+		}
+
+		pageSize, err := strconv.Atoi(query.Get("page_size"))
+		if err != nil {
+			http.Error(w, "invalid page_size", http.StatusBadRequest)
+			return
+		}
+
+		objects, nextPageToken, err := fake.list(
+			query.Get("namespace"),
+			query.Get("relation"),
+			subject,
+			query.Get("page_token"),
+			pageSize,
+		)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		tuples := make([]map[string]string, 0, len(objects))
+		for _, object := range objects {
+			tuples = append(tuples, map[string]string{
+				"namespace": query.Get("namespace"),
+				"object":    object,
+				"relation":  query.Get("relation"),
+			})
+		}
+
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"relation_tuples": tuples,
+			"next_page_token": nextPageToken,
+		})
+	})
+
+	mux.HandleFunc(ketoAlivePath, func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+	})
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	return &restRelationChecker{
+		baseURL:    server.URL,
+		httpClient: server.Client(),
+	}
+}
+
+func TestRelationCheckers(t *testing.T) {
+	admins := relationTuple{Namespace: "groups", Object: "admins", Relation: "member"}
+
+	fake := &fakeKeto{
+		tuples: []fakeTuple{
+			{relationTuple{Namespace: "docs", Object: "readme", Relation: "viewer"}, "alice"},
+			{relationTuple{Namespace: "docs", Object: "a", Relation: "owner"}, "bob"},
+			{relationTuple{Namespace: "docs", Object: "b", Relation: "owner"}, "bob"},
+			{relationTuple{Namespace: "docs", Object: "c", Relation: "owner"}, "bob"},
+			{relationTuple{Namespace: "docs", Object: "d", Relation: "owner"}, "bob"},
+			{relationTuple{Namespace: "docs", Object: "e", Relation: "owner"}, "bob"},
+			{relationTuple{Namespace: "docs", Object: "handbook", Relation: "viewer"}, admins.String()},
+			{relationTuple{Namespace: "docs", Object: "secret", Relation: "viewer"}, admins.String()},
+		},
+	}
+
+	checkers := map[string]func(t *testing.T, fake *fakeKeto) relationChecker{
+		ketoTransportGRPC: newGRPCTestChecker,
+		ketoTransportREST: newRESTTestChecker,
+	}
+
+	for transport, newChecker := range checkers {
+		t.Run(transport, func(t *testing.T) {
+			checker := newChecker(t, fake)
+			ctx := context.Background()
+
+			t.Run("check", func(t *testing.T) {
+				tests := []struct {
+					name      string
+					namespace string
+					object    string
+					relation  string
+					subject   string
+					want      bool
+					wantErr   bool
+				}{
+					{
+						name:      "allowed",
+						namespace: "docs",
+						object:    "readme",
+						relation:  "viewer",
+						subject:   "alice",
+						want:      true,
+					},
+					{
+						name:      "denied",
+						namespace: "docs",
+						object:    "readme",
+						relation:  "owner",
+						subject:   "alice",
+					},
+					{
+						name:      "denied for another subject",
+						namespace: "docs",
+						object:    "readme",
+						relation:  "viewer",
+						subject:   "bob",
+					},
+					{
+						name:      "upstream error",
+						namespace: unavailableNamespace,
+						object:    "readme",
+						relation:  "viewer",
+						subject:   "alice",
+						wantErr:   true,
+					},
+				}
+
+				for _, tt := range tests {
+					t.Run(tt.name, func(t *testing.T) {
+						got, err := checker.check(ctx, tt.namespace, tt.object, tt.relation, tt.subject)
+						if (err != nil) != tt.wantErr {
+							t.Fatalf("check() error = %v, wantErr %v", err, tt.wantErr)
+						}
+
+						if got != tt.want {
+							t.Errorf("check() = %v, want %v", got, tt.want)
+						}
+					})
+				}
+			})
+
+			t.Run("listObjects", func(t *testing.T) {
+				tests := []struct {
+					name      string
+					namespace string
+					relation  string
+					subject   ketoSubject
+					pageSize  int
+					want      []string
+					wantPages int
+					wantErr   bool
+				}{
+					{
+						name:      "single page",
+						namespace: "docs",
+						relation:  "viewer",
+						subject:   ketoSubject{id: "alice"},
+						pageSize:  10,
+						want:      []string{"readme"},
+						wantPages: 1,
+					},
+					{
+						name:      "paged",
+						namespace: "docs",
+						relation:  "owner",
+						subject:   ketoSubject{id: "bob"},
+						pageSize:  2,
+						want:      []string{"a", "b", "c", "d", "e"},
+						wantPages: 3,
+					},
+					{
+						name:      "none",
+						namespace: "docs",
+						relation:  "owner",
+						subject:   ketoSubject{id: "alice"},
+						pageSize:  10,
+						want:      []string{},
+						wantPages: 1,
+					},
+					{
+						name:      "subject set",
+						namespace: "docs",
+						relation:  "viewer",
+						subject:   ketoSubject{set: &admins},
+						pageSize:  10,
+						want:      []string{"handbook", "secret"},
+						wantPages: 1,
+					},
+					{
+						name:      "upstream error",
+						namespace: unavailableNamespace,
+						relation:  "viewer",
+						subject:   ketoSubject{id: "alice"},
+						pageSize:  10,
+						wantErr:   true,
+					},
+				}
+
+				for _, tt := range tests {
+					t.Run(tt.name, func(t *testing.T) {
+						got := []string{}
+						var pageToken string
+						var pages int
+						for {
+							objects, nextPageToken, err := checker.listObjects(
+								ctx,
+								tt.namespace,
+								tt.relation,
+								tt.subject,
+								pageToken,
+								tt.pageSize,
+							)
+							if (err != nil) != tt.wantErr {
+								t.Fatalf("listObjects() error = %v, wantErr %v", err, tt.wantErr)
+							}
+
+							if err != nil {
+								return
+							}
+
+							pages++
+							got = append(got, objects...)
+
+							if nextPageToken == "" {
+								break
+							}
+
+							if pages > len(tt.want) {
+								t.Fatalf("listObjects() did not stop paging after %d pages", pages)
+							}
+
+							pageToken = nextPageToken
+						}
+
+						if !reflect.DeepEqual(got, tt.want) {
+							t.Errorf("listObjects() = %v, want %v", got, tt.want)
+						}
+
+						if pages != tt.wantPages {
+							t.Errorf("listObjects() returned %d pages, want %d", pages, tt.wantPages)
+						}
+					})
+				}
+			})
+
+			t.Run("healthy", func(t *testing.T) {
+				err := checker.healthy(ctx)
+				if err != nil {
+					t.Errorf("healthy() error = %v", err)
+				}
+			})
+		})
+	}
+}
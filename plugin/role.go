@@ -0,0 +1,145 @@
+package plugin
+
+import (
+	"context"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/helper/strutil"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/pkg/errors"
+)
+
+// rolePrefix is the storage prefix for roles.
+const rolePrefix = "role/"
+
+// Role binds the relation tuples that may be requested at login to the settings of the issued token.
+type Role struct {
+	AllowedNamespaces     []string `json:"allowed_namespaces,omitempty"`
+	AllowedObjectPatterns []string `json:"allowed_object_patterns,omitempty"`
+	AllowedRelations      []string `json:"allowed_relations,omitempty"`
+
+	TokenPolicies []string `json:"token_policies,omitempty"`
+	TTLSeconds    int      `json:"ttl_seconds,omitempty"`
+	MaxTTLSeconds int      `json:"max_ttl_seconds,omitempty"`
+	TokenType     string   `json:"token_type,omitempty"`
+}
+
+// readRole reads the role with the given name from the storage.
+func (b *OryAuthBackend) readRole(ctx context.Context, s logical.Storage, name string) (*Role, error) {
+	b.Logger().Debug("reading role", "name", name)
+
+	entry, err := s.Get(ctx, rolePrefix+name)
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting role from storage")
+	}
+
+	if entry == nil {
+		b.Logger().Debug("role entry was nil", "name", name)
+		return nil, nil
+	}
+
+	role := &Role{}
+	err = entry.DecodeJSON(role)
+	if err != nil {
+		return nil, errors.Wrap(err, "error decoding role JSON")
+	}
+
+	b.Logger().Debug("successfully read role", "name", name)
+
+	return role, nil
+}
+
+// setRole stores the role with the given name in the storage.
+func (b *OryAuthBackend) setRole(ctx context.Context, s logical.Storage, name string, role *Role) error {
+	b.Logger().Debug("setting role", "name", name)
+
+	if role == nil {
+		return errors.New("role is not found")
+	}
+
+	entry, err := logical.StorageEntryJSON(rolePrefix+name, role)
+	if err != nil {
+		return errors.Wrap(err, "could not create JSON storage entry")
+	}
+
+	if err := s.Put(ctx, entry); err != nil {
+		return errors.Wrap(err, "could not store role in storage")
+	}
+
+	b.Logger().Debug("successfully set role", "name", name)
+
+	return nil
+}
+
+// validate checks that the role is usable.
+func (r *Role) validate() error {
+	switch {
+	case len(r.AllowedNamespaces) == 0:
+		return errors.New("allowed_namespaces must not be empty")
+	case len(r.AllowedObjectPatterns) == 0:
+		return errors.New("allowed_object_patterns must not be empty")
+	case len(r.AllowedRelations) == 0:
+		return errors.New("allowed_relations must not be empty")
+	case r.MaxTTLSeconds > 0 && r.TTLSeconds > r.MaxTTLSeconds:
+		return errors.New("ttl_seconds must not be greater than max_ttl_seconds")
+	}
+
+	_, err := parseTokenType(r.TokenType)
+
+	return err
+}
+
+// allows checks whether the role allows the namespace, object and relation to be requested.
+func (r *Role) allows(namespace, object, relation string) error {
+	if !strutil.StrListContainsGlob(r.AllowedNamespaces, namespace) {
+		return errors.Errorf("namespace %q is not allowed by the role", namespace)
+	}
+
+	if !strutil.StrListContainsGlob(r.AllowedObjectPatterns, object) {
+		return errors.Errorf("object %q is not allowed by the role", object)
+	}
+
+	// an empty relation is resolved from the relation hierarchy, which is limited to the allowed relations
+	if relation != "" && !strutil.StrListContainsGlob(r.AllowedRelations, relation) {
+		return errors.Errorf("relation %q is not allowed by the role", relation)
+	}
+
+	return nil
+}
+
+// getTTLs returns the TTL and max TTL of tokens issued for the role, falling back to the config.
+func (r *Role) getTTLs(config *Config) (time.Duration, time.Duration) {
+	ttl := time.Duration(config.TTLSeconds) * time.Second
+	maxTTL := time.Duration(config.MaxTTLSeconds) * time.Second
+
+	if r == nil {
+		return ttl, maxTTL
+	}
+
+	if r.TTLSeconds > 0 {
+		ttl = time.Duration(r.TTLSeconds) * time.Second
+	}
+
+	if r.MaxTTLSeconds > 0 {
+		maxTTL = time.Duration(r.MaxTTLSeconds) * time.Second
+	}
+
+	return ttl, maxTTL
+}
+
+// parseTokenType converts the token type name used in the role to a Vault token type.
+func parseTokenType(tokenType string) (logical.TokenType, error) {
+	switch tokenType {
+	case "", "default":
+		return logical.TokenTypeDefault, nil
+	case "service":
+		return logical.TokenTypeService, nil
+	case "batch":
+		return logical.TokenTypeBatch, nil
+	default:
+		return logical.TokenTypeDefault, errors.Errorf(
+			"invalid token_type %q, expected one of default, service or batch",
+			tokenType,
+		)
+	}
+}
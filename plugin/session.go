@@ -0,0 +1,249 @@
+package plugin
+
+import (
+	"fmt"
+	"time"
+
+	kratos "github.com/ory/kratos-client-go"
+	"github.com/pkg/errors"
+)
+
+// validateSession checks that the Kratos session is complete and currently valid before it is used.
+//
+// Kratos only returns active, unexpired sessions, so a session failing these checks means the response
+// is malformed or the clocks of Vault and Kratos disagree by more than the tolerated skew.
+func validateSession(session *kratos.Session, skew time.Duration, now time.Time) error {
+	switch {
+	case session == nil:
+		return errSessionInvalid(errors.New("kratos returned no session"))
+	case session.Id == "":
+		return errSessionInvalid(errors.New("session has no id"))
+	case session.Identity.Id == "":
+		return errSessionInvalid(errors.New("session has no identity"))
+	case session.Active == nil:
+		return errSessionInvalid(errors.New("session has no active state"))
+	case !*session.Active:
+		return errSessionInvalid(errors.New("session is not active"))
+	case session.ExpiresAt == nil || session.ExpiresAt.IsZero():
+		return errSessionInvalid(errors.New("session has no expiry"))
+	case !now.Before(session.ExpiresAt.Add(skew)):
+		return errSessionInvalid(errors.Errorf("session expired at %s", session.ExpiresAt.Format(time.RFC3339)))
+	case session.IssuedAt != nil && session.IssuedAt.After(now.Add(skew)):
+		return errSessionInvalid(errors.Errorf("session is issued in the future at %s", session.IssuedAt.Format(time.RFC3339)))
+	case session.AuthenticatedAt != nil && session.AuthenticatedAt.After(now.Add(skew)):
+		return errSessionInvalid(errors.Errorf(
+			"session is authenticated in the future at %s",
+			session.AuthenticatedAt.Format(time.RFC3339),
+		))
+	}
+
+	return nil
+}
+
+// aalLevels orders the Kratos authenticator assurance levels from lowest to highest.
+var aalLevels = []kratos.AuthenticatorAssuranceLevel{
+	kratos.AUTHENTICATORASSURANCELEVEL_AAL0,
+	kratos.AUTHENTICATORASSURANCELEVEL_AAL1,
+	kratos.AUTHENTICATORASSURANCELEVEL_AAL2,
+	kratos.AUTHENTICATORASSURANCELEVEL_AAL3,
+}
+
+// aalRank returns the position of the authenticator assurance level in aalLevels, or -1 if it is unknown.
+func aalRank(aal kratos.AuthenticatorAssuranceLevel) int {
+	for i, level := range aalLevels {
+		if level == aal {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// validateAAL checks that the authenticator assurance level can be required.
+func validateAAL(aal string) error {
+	if aal == "" || aalRank(kratos.AuthenticatorAssuranceLevel(aal)) >= 0 {
+		return nil
+	}
+
+	return errors.Errorf("invalid authenticator assurance level %q, expected one of aal0, aal1, aal2 or aal3", aal)
+}
+
+// requiredAAL returns the highest authenticator assurance level required by the config for the tuples.
+func requiredAAL(config *Config, tuples []relationTuple) kratos.AuthenticatorAssuranceLevel {
+	required := kratos.AuthenticatorAssuranceLevel(config.RequiredAAL)
+
+	for _, tuple := range tuples {
+		namespaceAAL, ok := config.NamespaceRequiredAAL[tuple.Namespace]
+		if !ok {
+			continue
+		}
+
+		if aalRank(kratos.AuthenticatorAssuranceLevel(namespaceAAL)) > aalRank(required) {
+			required = kratos.AuthenticatorAssuranceLevel(namespaceAAL)
+		}
+	}
+
+	return required
+}
+
+// checkAAL checks that the principal's session satisfies the authenticator assurance level required
+// for the tuples.
+//
+// The error names the Kratos `session_aal<n>_required` error ID, so the client knows to send the user
+// through a Kratos step-up login flow with `aal=aal<n>`.
+func (b *OryAuthBackend) checkAAL(config *Config, principal *loginPrincipal, tuples []relationTuple) error {
+	required := requiredAAL(config, tuples)
+	if required == "" || aalRank(required) <= 0 {
+		return nil
+	}
+
+	if principal.session == nil {
+		return errDenied(fmt.Sprintf("session_%s_required", required), errors.Errorf(
+			"%s is required, but the authenticator assurance level of a %s login cannot be verified",
+			required,
+			principal.method,
+		))
+	}
+
+	var current kratos.AuthenticatorAssuranceLevel
+	if principal.session.AuthenticatorAssuranceLevel != nil {
+		current = *principal.session.AuthenticatorAssuranceLevel
+	}
+
+	if aalRank(current) < aalRank(required) {
+		return errDenied(fmt.Sprintf("session_%s_required", required), errors.Errorf(
+			"%s is required, but the session has %q; complete a Kratos login flow with aal=%s",
+			required,
+			current,
+			required,
+		))
+	}
+
+	return nil
+}
+
+// maxSessionAge returns the strictest maximum session age required by the config for the tuples,
+// or zero if there is none.
+func maxSessionAge(config *Config, tuples []relationTuple) time.Duration {
+	maxAge := config.MaxSessionAge
+
+	for _, tuple := range tuples {
+		namespaceMaxAge, ok := config.NamespaceMaxSessionAge[tuple.Namespace]
+		if !ok || namespaceMaxAge <= 0 {
+			continue
+		}
+
+		if maxAge <= 0 || namespaceMaxAge < maxAge {
+			maxAge = namespaceMaxAge
+		}
+	}
+
+	return time.Duration(maxAge) * time.Second
+}
+
+// checkSessionAge checks that the principal's session was authenticated recently enough for the tuples,
+// and returns how long the session remains fresh, or zero if no maximum session age applies.
+//
+// The error names the Kratos `session_refresh_required` error ID, so the client knows to send the user
+// through a Kratos login flow with `refresh=true`.
+func (b *OryAuthBackend) checkSessionAge(
+	config *Config,
+	principal *loginPrincipal,
+	tuples []relationTuple,
+) (time.Duration, error) {
+	maxAge := maxSessionAge(config, tuples)
+	if maxAge <= 0 {
+		return 0, nil
+	}
+
+	if principal.session == nil {
+		return 0, errDenied("session_refresh_required", errors.Errorf(
+			"a session authenticated within %s is required, but the age of a %s login cannot be verified",
+			maxAge,
+			principal.method,
+		))
+	}
+
+	if principal.session.AuthenticatedAt == nil {
+		return 0, errDenied("session_refresh_required", errors.New("session has no authentication time"))
+	}
+
+	remaining := time.Until(principal.session.AuthenticatedAt.Add(maxAge))
+	if remaining <= 0 {
+		return 0, errDenied("session_refresh_required", errors.Errorf(
+			"a session authenticated within %s is required; complete a Kratos login flow with refresh=true",
+			maxAge,
+		))
+	}
+
+	return remaining, nil
+}
+
+// checkIdentity checks that the identity of the principal's session is active and has the verified
+// addresses required by the config.
+//
+// Each failure has a distinct error ID, so that support staff can tell users what to fix.
+func (b *OryAuthBackend) checkIdentity(config *Config, principal *loginPrincipal) error {
+	if !config.RequireActiveIdentity && !config.RequireVerifiedAddress && config.RequiredVerifiedAddressVia == "" {
+		return nil
+	}
+
+	if principal.session == nil {
+		return errDenied("identity_unverifiable", errors.Errorf(
+			"the identity of a %s login cannot be checked for its state or verified addresses",
+			principal.method,
+		))
+	}
+
+	identity := principal.session.Identity
+
+	if config.RequireActiveIdentity {
+		if identity.State == nil {
+			return errDenied("identity_state_unknown", errors.New("the identity's state was not returned by Kratos"))
+		}
+
+		if *identity.State != kratos.IDENTITYSTATE_ACTIVE {
+			return errDenied("identity_inactive", errors.Errorf(
+				"the identity is %s; an administrator must activate it",
+				*identity.State,
+			))
+		}
+	}
+
+	if config.RequireVerifiedAddress {
+		var verified bool
+		for _, address := range identity.VerifiableAddresses {
+			if address.Verified {
+				verified = true
+				break
+			}
+		}
+
+		if !verified {
+			return errDenied("identity_address_unverified", errors.New(
+				"the identity has no verified address; complete a Kratos verification flow",
+			))
+		}
+	}
+
+	if config.RequiredVerifiedAddressVia != "" {
+		var verified bool
+		for _, address := range identity.VerifiableAddresses {
+			if address.Via == config.RequiredVerifiedAddressVia && address.Verified {
+				verified = true
+				break
+			}
+		}
+
+		if !verified {
+			code := fmt.Sprintf("identity_%s_address_unverified", config.RequiredVerifiedAddressVia)
+
+			return errDenied(code, errors.Errorf(
+				"the identity has no verified %s address; complete a Kratos verification flow",
+				config.RequiredVerifiedAddressVia,
+			))
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,331 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	kratos "github.com/ory/kratos-client-go"
+	"github.com/pkg/errors"
+)
+
+// newTestBackend returns a backend set up with the config in in-memory storage.
+func newTestBackend(t *testing.T, config *Config) (*OryAuthBackend, logical.Storage) {
+	t.Helper()
+
+	ctx := context.Background()
+
+	b, err := Factory(ctx, logical.TestBackendConfig())
+	if err != nil {
+		t.Fatalf("failed to create backend: %v", err)
+	}
+
+	backend := b.(*OryAuthBackend)
+	storage := &logical.InmemStorage{}
+
+	err = backend.setConfig(ctx, storage, config)
+	if err != nil {
+		t.Fatalf("failed to set config: %v", err)
+	}
+
+	t.Cleanup(backend.Close)
+
+	return backend, storage
+}
+
+// errorCode returns the code of a login error, or an empty string if the error has none.
+func errorCode(err error) string {
+	var loginErr *loginError
+	if errors.As(err, &loginErr) {
+		return loginErr.code
+	}
+
+	return ""
+}
+
+func TestValidateSession(t *testing.T) {
+	now := time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC)
+	skew := 30 * time.Second
+
+	ptr := func(t time.Time) *time.Time { return &t }
+	active := func(active bool) *bool { return &active }
+
+	// valid returns a valid session changed by update.
+	valid := func(update func(session *kratos.Session)) *kratos.Session {
+		session := &kratos.Session{
+			Id:              "session",
+			Active:          active(true),
+			ExpiresAt:       ptr(now.Add(time.Hour)),
+			IssuedAt:        ptr(now.Add(-time.Hour)),
+			AuthenticatedAt: ptr(now.Add(-time.Hour)),
+			Identity:        kratos.Identity{Id: "identity"},
+		}
+
+		if update != nil {
+			update(session)
+		}
+
+		return session
+	}
+
+	tests := []struct {
+		name    string
+		session *kratos.Session
+		wantErr bool
+	}{
+		{
+			name:    "valid",
+			session: valid(nil),
+		},
+		{
+			name:    "nil session",
+			session: nil,
+			wantErr: true,
+		},
+		{
+			name:    "empty id",
+			session: valid(func(s *kratos.Session) { s.Id = "" }),
+			wantErr: true,
+		},
+		{
+			name:    "empty identity id",
+			session: valid(func(s *kratos.Session) { s.Identity = kratos.Identity{} }),
+			wantErr: true,
+		},
+		{
+			name:    "nil active",
+			session: valid(func(s *kratos.Session) { s.Active = nil }),
+			wantErr: true,
+		},
+		{
+			name:    "inactive",
+			session: valid(func(s *kratos.Session) { s.Active = active(false) }),
+			wantErr: true,
+		},
+		{
+			name:    "nil expiry",
+			session: valid(func(s *kratos.Session) { s.ExpiresAt = nil }),
+			wantErr: true,
+		},
+		{
+			name:    "zero expiry",
+			session: valid(func(s *kratos.Session) { s.ExpiresAt = &time.Time{} }),
+			wantErr: true,
+		},
+		{
+			name:    "expired within the skew",
+			session: valid(func(s *kratos.Session) { s.ExpiresAt = ptr(now.Add(-skew / 2)) }),
+		},
+		{
+			name:    "expired outside the skew",
+			session: valid(func(s *kratos.Session) { s.ExpiresAt = ptr(now.Add(-2 * skew)) }),
+			wantErr: true,
+		},
+		{
+			name:    "expiring at the end of the skew",
+			session: valid(func(s *kratos.Session) { s.ExpiresAt = ptr(now.Add(-skew)) }),
+			wantErr: true,
+		},
+		{
+			name:    "nil issued and authenticated times",
+			session: valid(func(s *kratos.Session) { s.IssuedAt, s.AuthenticatedAt = nil, nil }),
+		},
+		{
+			name:    "issued in the future within the skew",
+			session: valid(func(s *kratos.Session) { s.IssuedAt = ptr(now.Add(skew / 2)) }),
+		},
+		{
+			name:    "issued in the future outside the skew",
+			session: valid(func(s *kratos.Session) { s.IssuedAt = ptr(now.Add(2 * skew)) }),
+			wantErr: true,
+		},
+		{
+			name:    "authenticated in the future outside the skew",
+			session: valid(func(s *kratos.Session) { s.AuthenticatedAt = ptr(now.Add(2 * skew)) }),
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateSession(tt.session, skew, now)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("validateSession() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if err != nil && errorCode(err) != errCodeSessionInvalid {
+				t.Errorf("validateSession() error code = %q, want %q", errorCode(err), errCodeSessionInvalid)
+			}
+		})
+	}
+}
+
+func TestGetKratosPrincipalMalformedSessions(t *testing.T) {
+	now := time.Now().UTC()
+	future := now.Add(time.Hour).Format(time.RFC3339)
+	past := now.Add(-time.Hour).Format(time.RFC3339)
+
+	tests := []struct {
+		name string
+		body string
+
+		// wantErr is whether the session is rejected, and otherwise its principal's TTL is capped
+		wantErr bool
+	}{
+		{
+			name: "valid",
+			body: fmt.Sprintf(`{"id":"session","active":true,"expires_at":%q,"identity":{"id":"identity"}}`, future),
+		},
+		{
+			name:    "not json",
+			body:    `<html>`,
+			wantErr: true,
+		},
+		{
+			name:    "null",
+			body:    `null`,
+			wantErr: true,
+		},
+		{
+			name:    "empty object",
+			body:    `{}`,
+			wantErr: true,
+		},
+		{
+			name:    "empty id",
+			body:    fmt.Sprintf(`{"active":true,"expires_at":%q,"identity":{"id":"identity"}}`, future),
+			wantErr: true,
+		},
+		{
+			name:    "no identity",
+			body:    fmt.Sprintf(`{"id":"session","active":true,"expires_at":%q}`, future),
+			wantErr: true,
+		},
+		{
+			name:    "no active state",
+			body:    fmt.Sprintf(`{"id":"session","expires_at":%q,"identity":{"id":"identity"}}`, future),
+			wantErr: true,
+		},
+		{
+			name:    "inactive",
+			body:    fmt.Sprintf(`{"id":"session","active":false,"expires_at":%q,"identity":{"id":"identity"}}`, future),
+			wantErr: true,
+		},
+		{
+			name:    "no expiry",
+			body:    `{"id":"session","active":true,"identity":{"id":"identity"}}`,
+			wantErr: true,
+		},
+		{
+			name:    "expired",
+			body:    fmt.Sprintf(`{"id":"session","active":true,"expires_at":%q,"identity":{"id":"identity"}}`, past),
+			wantErr: true,
+		},
+		{
+			name: "issued in the future",
+			body: fmt.Sprintf(
+				`{"id":"session","active":true,"expires_at":%q,"issued_at":%q,"identity":{"id":"identity"}}`,
+				future,
+				future,
+			),
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != kratosWhoamiPath {
+					http.NotFound(w, r)
+					return
+				}
+
+				w.Header().Set("Content-Type", "application/json")
+				_, _ = w.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+
+			maxRetries := 0
+			config := &Config{
+				KratosURL:        server.URL,
+				KratosAPIVersion: kratosAPIVersionV1,
+				KratosMaxRetries: &maxRetries,
+			}
+
+			b, storage := newTestBackend(t, config)
+
+			data := &framework.FieldData{
+				Raw:    map[string]interface{}{"kratos_session_token": "token"},
+				Schema: loginFields,
+			}
+
+			req := &logical.Request{Storage: storage}
+
+			principal, err := b.getKratosPrincipal(context.Background(), req, data, config)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("getKratosPrincipal() succeeded, want an error")
+				}
+
+				if errorCode(err) != errCodeSessionInvalid {
+					t.Errorf("getKratosPrincipal() error code = %q, want %q", errorCode(err), errCodeSessionInvalid)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("getKratosPrincipal() error = %v", err)
+			}
+
+			ttl := principal.capTTL(24*time.Hour, true)
+			if ttl <= 0 || ttl > time.Hour {
+				t.Errorf("capTTL() = %s, want at most the session lifetime", ttl)
+			}
+		})
+	}
+}
+
+func TestCapTTL(t *testing.T) {
+	ptr := func(t time.Time) *time.Time { return &t }
+
+	tests := []struct {
+		name      string
+		principal *loginPrincipal
+		ttl       time.Duration
+		want      time.Duration
+	}{
+		{
+			name:      "no expiry",
+			principal: &loginPrincipal{},
+			ttl:       time.Hour,
+			want:      time.Hour,
+		},
+		{
+			name:      "expired within the clock skew",
+			principal: &loginPrincipal{expiresAt: ptr(time.Now().Add(-time.Minute))},
+			ttl:       time.Hour,
+			want:      time.Second,
+		},
+		{
+			name:      "zero expiry",
+			principal: &loginPrincipal{expiresAt: &time.Time{}},
+			ttl:       time.Hour,
+			want:      time.Second,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.principal.capTTL(tt.ttl, true)
+			if got != tt.want {
+				t.Errorf("capTTL() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
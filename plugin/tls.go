@@ -0,0 +1,83 @@
+package plugin
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+
+	"github.com/pkg/errors"
+)
+
+// tlsVersions maps the TLS version names accepted in the config to their crypto/tls values.
+var tlsVersions = map[string]uint16{
+	"tls10": tls.VersionTLS10,
+	"tls11": tls.VersionTLS11,
+	"tls12": tls.VersionTLS12,
+	"tls13": tls.VersionTLS13,
+}
+
+// tlsSettings are the TLS settings of a connection to an Ory service.
+type tlsSettings struct {
+	// caCert is a PEM bundle of the CAs trusted in addition to the system roots.
+	caCert string
+
+	// clientCert and clientKey are the PEM client certificate and key presented for mutual TLS.
+	clientCert string
+	clientKey  string
+
+	// serverName overrides the name the server certificate is verified against.
+	serverName string
+
+	// minVersion is the minimum TLS version, e.g. tls12 (the default).
+	minVersion string
+
+	// skipVerify disables the verification of the server certificate.
+	skipVerify bool
+}
+
+// newTLSConfig converts the TLS settings to a TLS config.
+func newTLSConfig(settings tlsSettings) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         settings.serverName,
+		InsecureSkipVerify: settings.skipVerify,
+	}
+
+	if settings.minVersion != "" {
+		version, ok := tlsVersions[settings.minVersion]
+		if !ok {
+			return nil, errors.Errorf(
+				"invalid minimum TLS version %q, expected one of tls10, tls11, tls12 or tls13",
+				settings.minVersion,
+			)
+		}
+
+		tlsConfig.MinVersion = version
+	}
+
+	if settings.caCert != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+
+		if !pool.AppendCertsFromPEM([]byte(settings.caCert)) {
+			return nil, errors.New("CA certificate does not contain any PEM certificates")
+		}
+
+		tlsConfig.RootCAs = pool
+	}
+
+	switch {
+	case settings.clientCert != "" && settings.clientKey != "":
+		cert, err := tls.X509KeyPair([]byte(settings.clientCert), []byte(settings.clientKey))
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid client certificate or key")
+		}
+
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	case settings.clientCert != "", settings.clientKey != "":
+		return nil, errors.New("client certificate and key must be set together")
+	}
+
+	return tlsConfig, nil
+}
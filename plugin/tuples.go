@@ -0,0 +1,457 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/strutil"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/pkg/errors"
+)
+
+const (
+	// maxLoginTuples bounds the number of relation tuples that can be checked in one login.
+	maxLoginTuples = 32
+
+	// maxConcurrentChecks bounds the number of concurrent Keto checks made during one login.
+	maxConcurrentChecks = 8
+
+	// listObjectsPageSize is the number of relation tuples requested from Keto per page when listing objects.
+	listObjectsPageSize = 100
+
+	// maxListObjectsPages bounds the number of pages requested from Keto when listing objects.
+	maxListObjectsPages = 50
+)
+
+// relationTuple is a Keto relation tuple requested at login, without its subject.
+type relationTuple struct {
+	Namespace string `json:"namespace"`
+	Object    string `json:"object"`
+	Relation  string `json:"relation"`
+}
+
+// String returns the tuple in Keto's `namespace:object#relation` notation.
+func (t relationTuple) String() string {
+	if t.Relation == "" {
+		return fmt.Sprintf("%s:%s", t.Namespace, t.Object)
+	}
+
+	return fmt.Sprintf("%s:%s#%s", t.Namespace, t.Object, t.Relation)
+}
+
+// policy returns the name of the policy granted by the tuple.
+func (t relationTuple) policy() string {
+	return strings.Join([]string{t.Namespace, t.Relation}, "_")
+}
+
+// getTuples returns the relation tuples requested at login, either from 'tuples' or from
+// the 'namespace', 'object' and 'relation' fields.
+func (b *OryAuthBackend) getTuples(data *framework.FieldData) ([]relationTuple, error) {
+	val, ok := data.GetOk("tuples")
+	if !ok {
+		namespace, err := b.getNamespace(data)
+		if err != nil {
+			return nil, err
+		}
+
+		// the object may be omitted to list every object the subject has the relation to
+		var object string
+		if _, ok := data.GetOk("object"); ok {
+			object, err = b.getObject(data)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		// the relation may be omitted to select the highest relation in the namespace's hierarchy
+		var relation string
+		if _, ok := data.GetOk("relation"); ok {
+			relation, err = b.getRelation(data)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		return []relationTuple{{Namespace: namespace, Object: object, Relation: relation}}, nil
+	}
+
+	b.Logger().Debug("getting tuples from data")
+
+	for _, field := range []string{"namespace", "object", "relation"} {
+		if _, ok := data.GetOk(field); ok {
+			return nil, errors.Errorf("%s cannot be combined with tuples", field)
+		}
+	}
+
+	rawTuples, ok := val.([]interface{})
+	if !ok || len(rawTuples) == 0 {
+		return nil, errors.New("missing tuples")
+	}
+
+	if len(rawTuples) > maxLoginTuples {
+		return nil, errors.Errorf("at most %d tuples can be requested", maxLoginTuples)
+	}
+
+	tuples := make([]relationTuple, 0, len(rawTuples))
+	for i, rawTuple := range rawTuples {
+		tuple, err := parseTuple(rawTuple)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid tuple at index %d", i)
+		}
+
+		tuples = append(tuples, tuple)
+	}
+
+	return tuples, nil
+}
+
+// parseTuple parses a tuple given either as an object with namespace, object and relation keys,
+// or as a string in Keto's `namespace:object#relation` notation.
+func parseTuple(raw interface{}) (relationTuple, error) {
+	var tuple relationTuple
+
+	switch v := raw.(type) {
+	case string:
+		namespace, rest, ok := strings.Cut(v, ":")
+		if !ok {
+			return tuple, errors.Errorf("%q is not in the format namespace:object#relation", v)
+		}
+
+		// the relation may be omitted to select the highest relation in the namespace's hierarchy
+		hash := strings.LastIndex(rest, "#")
+		if hash < 0 {
+			tuple = relationTuple{Namespace: namespace, Object: rest}
+			break
+		}
+
+		tuple = relationTuple{Namespace: namespace, Object: rest[:hash], Relation: rest[hash+1:]}
+		if tuple.Relation == "" {
+			return tuple, errors.New("missing relation")
+		}
+	case map[string]interface{}:
+		tuple.Namespace, _ = v["namespace"].(string)
+		tuple.Object, _ = v["object"].(string)
+		tuple.Relation, _ = v["relation"].(string)
+
+		if rawRelation, ok := v["relation"]; ok && tuple.Relation == "" {
+			return tuple, errors.Errorf("relation was %v, expected a non-empty string", rawRelation)
+		}
+	default:
+		return tuple, errors.Errorf("tuple was a %T, expected an object or a string", raw)
+	}
+
+	switch {
+	case tuple.Namespace == "":
+		return tuple, errors.New("missing namespace")
+	case tuple.Object == "":
+		return tuple, errors.New("missing object")
+	}
+
+	return tuple, nil
+}
+
+// getInternalTuples returns the relation tuples granted at login from the token's internal data.
+func getInternalTuples(internalData map[string]interface{}) ([]relationTuple, error) {
+	rawTuples, ok := internalData["tuples"].([]interface{})
+	if !ok || len(rawTuples) == 0 {
+		return nil, errors.New("missing tuples")
+	}
+
+	tuples := make([]relationTuple, 0, len(rawTuples))
+	for i, rawTuple := range rawTuples {
+		tuple, err := parseTuple(rawTuple)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid tuple at index %d", i)
+		}
+
+		if tuple.Relation == "" {
+			return nil, errors.Errorf("missing relation in tuple at index %d", i)
+		}
+
+		tuples = append(tuples, tuple)
+	}
+
+	return tuples, nil
+}
+
+// listTuples returns a tuple for every object in the namespace that the subject has the relation to,
+// limited to the objects allowed by the role. The query is a tuple without an object.
+//
+// Only relation tuples naming the subject directly are listed, not those granted through subject sets.
+// The login fails if more objects than config.MaxListedObjects are found, to keep tokens bounded.
+func (b *OryAuthBackend) listTuples(
+	ctx context.Context,
+	req *logical.Request,
+	config *Config,
+	role *Role,
+	query relationTuple,
+	subject string,
+) ([]relationTuple, error) {
+	b.Logger().Debug("listing objects", "namespace", query.Namespace, "relation", query.Relation)
+
+	if config.MaxListedObjects <= 0 {
+		return nil, errBadRequest(errors.New("object is required as listing objects is not enabled"))
+	}
+
+	if query.Relation == "" {
+		return nil, errBadRequest(errors.New("relation is required to list objects"))
+	}
+
+	if role != nil {
+		if !strutil.StrListContainsGlob(role.AllowedNamespaces, query.Namespace) {
+			return nil, errRelationDenied(errors.Errorf("namespace %q is not allowed by the role", query.Namespace))
+		}
+
+		if !strutil.StrListContainsGlob(role.AllowedRelations, query.Relation) {
+			return nil, errRelationDenied(errors.Errorf("relation %q is not allowed by the role", query.Relation))
+		}
+	}
+
+	ketoClient, err := b.getKetoClient(ctx, req.Storage)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get keto client")
+	}
+
+	var tuples []relationTuple
+	var pageToken string
+	for page := 0; ; page++ {
+		if page >= maxListObjectsPages {
+			return nil, errDenied("too_many_objects", errors.Errorf(
+				"subject has more than %d relation tuples in namespace %q",
+				maxListObjectsPages*listObjectsPageSize,
+				query.Namespace,
+			))
+		}
+
+		listCtx, cancel := context.WithTimeout(ctx, ketoClient.readTimeout)
+		objects, nextPageToken, err := ketoClient.checker.listObjects(
+			listCtx,
+			query.Namespace,
+			query.Relation,
+			ketoSubject{id: subject},
+			pageToken,
+			listObjectsPageSize,
+		)
+		cancel()
+
+		if err != nil {
+			return nil, errUpstreamUnavailable(errors.Wrap(err, "failed to list objects"))
+		}
+
+		for _, object := range objects {
+			if role != nil && !strutil.StrListContainsGlob(role.AllowedObjectPatterns, object) {
+				continue
+			}
+
+			tuples = append(tuples, relationTuple{Namespace: query.Namespace, Object: object, Relation: query.Relation})
+		}
+
+		if len(tuples) > config.MaxListedObjects {
+			return nil, errDenied("too_many_objects", errors.Errorf(
+				"subject has the relation to more than %d objects in namespace %q",
+				config.MaxListedObjects,
+				query.Namespace,
+			))
+		}
+
+		if nextPageToken == "" {
+			break
+		}
+
+		pageToken = nextPageToken
+	}
+
+	if len(tuples) == 0 {
+		return nil, errRelationDenied(errors.Errorf(
+			"subject does not have the relation %q to any object in namespace %q",
+			query.Relation,
+			query.Namespace,
+		))
+	}
+
+	return tuples, nil
+}
+
+// relationCandidates returns the relations to check for each tuple, highest first.
+//
+// A tuple with a relation has that relation as its only candidate. A tuple without a relation
+// has the relations of its namespace's hierarchy as candidates, limited to those allowed by the role.
+func (b *OryAuthBackend) relationCandidates(
+	config *Config,
+	role *Role,
+	tuples []relationTuple,
+) ([][]string, error) {
+	candidates := make([][]string, len(tuples))
+
+	for i, tuple := range tuples {
+		if tuple.Relation != "" {
+			candidates[i] = []string{tuple.Relation}
+			continue
+		}
+
+		hierarchy, ok := config.RelationHierarchy[tuple.Namespace]
+		if !ok || len(hierarchy) == 0 {
+			return nil, errors.Errorf(
+				"relation is required as namespace %q has no relation hierarchy",
+				tuple.Namespace,
+			)
+		}
+
+		for _, relation := range hierarchy {
+			if role == nil || strutil.StrListContainsGlob(role.AllowedRelations, relation) {
+				candidates[i] = append(candidates[i], relation)
+			}
+		}
+
+		if len(candidates[i]) == 0 {
+			return nil, errors.Errorf(
+				"no relation in the hierarchy of namespace %q is allowed by the role",
+				tuple.Namespace,
+			)
+		}
+	}
+
+	return candidates, nil
+}
+
+// checkTuples concurrently checks whether the subject has each of the relation tuples and
+// returns the allowed tuples. Each tuple is granted the first of its candidate relations that
+// the subject has. Unless allowPartial is set, every tuple must be allowed.
+func (b *OryAuthBackend) checkTuples(
+	ctx context.Context,
+	req *logical.Request,
+	tuples []relationTuple,
+	candidates [][]string,
+	subject string,
+	allowPartial bool,
+) ([]relationTuple, error) {
+	b.Logger().Debug("checking tuples", "count", len(tuples))
+
+	relations := make([]string, len(tuples))
+	errs := make([]error, len(tuples))
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, maxConcurrentChecks)
+
+	for i, tuple := range tuples {
+		wg.Add(1)
+		sem <- struct{}{}
+
+		go func(i int, tuple relationTuple) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			relations[i], errs[i] = b.checkHighestRelation(ctx, req, tuple, candidates[i], subject)
+		}(i, tuple)
+	}
+
+	wg.Wait()
+
+	var granted []relationTuple
+	var denied []string
+	for i, tuple := range tuples {
+		if errs[i] != nil {
+			return nil, errUpstreamUnavailable(errors.Wrapf(errs[i], "failed to check %s", tuple))
+		}
+
+		if relations[i] != "" {
+			tuple.Relation = relations[i]
+			granted = append(granted, tuple)
+		} else {
+			denied = append(denied, tuple.String())
+		}
+	}
+
+	if len(granted) == 0 || (len(denied) > 0 && !allowPartial) {
+		return nil, errRelationDenied(errors.Errorf(
+			"subject does not have the relation to the object in the namespace: %s",
+			strings.Join(denied, ", "),
+		))
+	}
+
+	if len(denied) > 0 {
+		b.Logger().Debug("some tuples were denied", "denied", denied)
+	}
+
+	return granted, nil
+}
+
+// checkHighestRelation returns the first of the candidate relations that the subject has to the
+// object in the namespace, or an empty string if it has none of them.
+func (b *OryAuthBackend) checkHighestRelation(
+	ctx context.Context,
+	req *logical.Request,
+	tuple relationTuple,
+	candidates []string,
+	subject string,
+) (string, error) {
+	for _, relation := range candidates {
+		allowed, err := b.checkRelation(ctx, req, tuple.Namespace, tuple.Object, relation, subject)
+		if err != nil {
+			return "", err
+		}
+
+		if allowed {
+			return relation, nil
+		}
+	}
+
+	return "", nil
+}
+
+// tuplesToPolicies returns the union of the policies granted by the tuples.
+func tuplesToPolicies(tuples []relationTuple) []string {
+	policies := make([]string, 0, len(tuples))
+	for _, tuple := range tuples {
+		policies = append(policies, tuple.policy())
+	}
+
+	return strutil.RemoveDuplicatesStable(policies, false)
+}
+
+// tuplesToInternalData returns the tuples in the form they are read back from the token's internal data.
+func tuplesToInternalData(tuples []relationTuple) []interface{} {
+	internalTuples := make([]interface{}, 0, len(tuples))
+	for _, tuple := range tuples {
+		internalTuples = append(internalTuples, map[string]interface{}{
+			"namespace": tuple.Namespace,
+			"object":    tuple.Object,
+			"relation":  tuple.Relation,
+		})
+	}
+
+	return internalTuples
+}
+
+// tuplesToMetadata returns the alias metadata describing the tuples.
+//
+// The first tuple is always described by the namespace, object and relation keys. When there
+// is more than one tuple, every tuple is also described by keys suffixed with its index
+// (e.g. object_1), and tuple_count holds the number of tuples.
+func tuplesToMetadata(tuples []relationTuple) map[string]string {
+	metadata := make(map[string]string)
+
+	if len(tuples) == 0 {
+		return metadata
+	}
+
+	metadata["namespace"] = tuples[0].Namespace
+	metadata["object"] = tuples[0].Object
+	metadata["relation"] = tuples[0].Relation
+
+	if len(tuples) == 1 {
+		return metadata
+	}
+
+	metadata["tuple_count"] = fmt.Sprint(len(tuples))
+	for i, tuple := range tuples {
+		metadata[fmt.Sprintf("namespace_%d", i)] = tuple.Namespace
+		metadata[fmt.Sprintf("object_%d", i)] = tuple.Object
+		metadata[fmt.Sprintf("relation_%d", i)] = tuple.Relation
+	}
+
+	return metadata
+}
@@ -0,0 +1,250 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	kratos "github.com/ory/kratos-client-go"
+	"github.com/pkg/errors"
+)
+
+const (
+	// kratosAPIVersionAuto detects the Kratos API generation from the Kratos version endpoint.
+	kratosAPIVersionAuto = "auto"
+
+	// kratosAPIVersionV0alpha2 validates sessions with the V0alpha2Api of Kratos before v1.
+	kratosAPIVersionV0alpha2 = "v0alpha2"
+
+	// kratosAPIVersionV1 validates sessions with the FrontendApi of Kratos v1 and later.
+	kratosAPIVersionV1 = "v1"
+
+	// kratosWhoamiPath is the path of the Kratos endpoint returning the session of a cookie or token.
+	kratosWhoamiPath = "/sessions/whoami"
+
+	// kratosAPIVersionRetryInterval is how long the v0alpha2 API is used after the Kratos API generation
+	// could not be detected, before the detection is retried.
+	kratosAPIVersionRetryInterval = time.Minute
+
+	// maxSessionResponseSize bounds the size of a session response read from Kratos.
+	maxSessionResponseSize = 1 << 20
+)
+
+// sessionValidator validates Kratos session cookies and tokens with one generation of the Kratos API.
+type sessionValidator interface {
+	// toSession returns the session of the cookie or token, exactly one of which is set.
+	// Like the Kratos client, it returns the response along with an error for non-2xx statuses.
+	toSession(ctx context.Context, cookie, token string) (*kratos.Session, *http.Response, error)
+}
+
+// validateKratosAPIVersion checks that the Kratos API version can be used.
+func validateKratosAPIVersion(version string) error {
+	switch version {
+	case "", kratosAPIVersionAuto, kratosAPIVersionV0alpha2, kratosAPIVersionV1:
+		return nil
+	default:
+		return errors.Errorf("invalid kratos_api_version %q, expected one of auto, v0alpha2 or v1", version)
+	}
+}
+
+// v0alpha2SessionValidator validates sessions with the V0alpha2Api of the Kratos client.
+type v0alpha2SessionValidator struct {
+	client *kratos.APIClient
+}
+
+// toSession returns the session of the cookie or token.
+func (v *v0alpha2SessionValidator) toSession(
+	ctx context.Context,
+	cookie, token string,
+) (*kratos.Session, *http.Response, error) {
+	req := v.client.V0alpha2Api.ToSession(ctx)
+	if cookie != "" {
+		req = req.Cookie(cookie)
+	}
+	if token != "" {
+		req = req.XSessionToken(token)
+	}
+
+	return req.Execute()
+}
+
+// frontendSessionValidator validates sessions with the FrontendApi of Kratos v1, which the Kratos client
+// used by the plugin predates, so whoami requests are made directly with the client's HTTP configuration.
+type frontendSessionValidator struct {
+	config *kratos.Configuration
+}
+
+// toSession returns the session of the cookie or token.
+func (v *frontendSessionValidator) toSession(
+	ctx context.Context,
+	cookie, token string,
+) (*kratos.Session, *http.Response, error) {
+	baseURL, err := v.config.ServerURL(0, nil)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "invalid kratos URL")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(baseURL, "/")+kratosWhoamiPath, nil)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to create whoami request")
+	}
+
+	for name, value := range v.config.DefaultHeader {
+		req.Header.Set(name, value)
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", v.config.UserAgent)
+
+	if cookie != "" {
+		req.Header.Set("Cookie", cookie)
+	}
+	if token != "" {
+		req.Header.Set("X-Session-Token", token)
+	}
+
+	httpClient := v.config.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(res.Body, maxSessionResponseSize))
+	if err != nil {
+		return nil, res, errors.Wrap(err, "failed to read whoami response")
+	}
+
+	if res.StatusCode < http.StatusOK || res.StatusCode >= http.StatusMultipleChoices {
+		return nil, res, errors.Errorf("whoami request failed: %s", res.Status)
+	}
+
+	session := &kratos.Session{}
+	err = json.Unmarshal(body, session)
+	if err != nil {
+		return nil, res, errors.Wrap(err, "failed to decode whoami response")
+	}
+
+	return session, res, nil
+}
+
+// getSessionValidator returns the session validator for the configured or detected Kratos API generation.
+//
+// The version is detected without holding the mutex, as it is a request to Kratos that can take several
+// seconds of retries. If the detection fails, the v0alpha2 validator is used until it is retried by a
+// single login once kratosAPIVersionRetryInterval has passed.
+func (b *OryAuthBackend) getSessionValidator(
+	ctx context.Context,
+	s logical.Storage,
+	config *Config,
+) (sessionValidator, error) {
+	now := time.Now()
+
+	b.sessionValidatorMutex.Lock()
+	validator := b.sessionValidator
+	generation := b.sessionValidatorGeneration
+	retry := validator != nil && !b.sessionValidatorRetryAt.IsZero() && !now.Before(b.sessionValidatorRetryAt)
+	if retry {
+		// the other logins keep using the fallback while this one retries the detection
+		b.sessionValidatorRetryAt = now.Add(kratosAPIVersionRetryInterval)
+	}
+	b.sessionValidatorMutex.Unlock()
+
+	if validator != nil && !retry {
+		return validator, nil
+	}
+
+	client, err := b.getKratosClient(ctx, s)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get Kratos client")
+	}
+
+	var retryAt time.Time
+	apiVersion := config.KratosAPIVersion
+	if apiVersion == "" || apiVersion == kratosAPIVersionAuto {
+		apiVersion, err = b.detectKratosAPIVersion(ctx, config, client)
+		if err != nil {
+			b.Logger().Warn(
+				"could not detect the kratos api version, using v0alpha2",
+				"retry_in", kratosAPIVersionRetryInterval,
+				"err", err,
+			)
+
+			apiVersion = kratosAPIVersionV0alpha2
+			retryAt = time.Now().Add(kratosAPIVersionRetryInterval)
+		}
+	}
+
+	b.Logger().Debug("creating kratos session validator", "api_version", apiVersion)
+
+	switch apiVersion {
+	case kratosAPIVersionV1:
+		validator = &frontendSessionValidator{config: client.GetConfig()}
+	default:
+		validator = &v0alpha2SessionValidator{client: client}
+	}
+
+	b.sessionValidatorMutex.Lock()
+	defer b.sessionValidatorMutex.Unlock()
+
+	// the config changed while detecting, so the validator may use a discarded client
+	if generation != b.sessionValidatorGeneration {
+		return validator, nil
+	}
+
+	b.sessionValidator = validator
+	b.sessionValidatorRetryAt = retryAt
+
+	return validator, nil
+}
+
+// closeSessionValidator discards the session validator, so that the API generation is chosen again.
+func (b *OryAuthBackend) closeSessionValidator() {
+	b.sessionValidatorMutex.Lock()
+	defer b.sessionValidatorMutex.Unlock()
+
+	b.sessionValidator = nil
+	b.sessionValidatorRetryAt = time.Time{}
+	b.sessionValidatorGeneration++
+}
+
+// detectKratosAPIVersion returns the Kratos API generation served by Kratos, based on its version.
+func (b *OryAuthBackend) detectKratosAPIVersion(
+	ctx context.Context,
+	config *Config,
+	client *kratos.APIClient,
+) (string, error) {
+	var version string
+	_, err := b.callKratos(ctx, config, "getVersion", func(ctx context.Context) (*http.Response, error) {
+		res, httpRes, err := client.MetadataApi.GetVersion(ctx).Execute()
+		if res != nil {
+			version = res.GetVersion()
+		}
+
+		return httpRes, err
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "failed to get kratos version")
+	}
+
+	major, err := strconv.Atoi(strings.SplitN(strings.TrimPrefix(version, "v"), ".", 2)[0])
+	if err != nil {
+		return "", errors.Errorf("could not parse kratos version %q", version)
+	}
+
+	b.Logger().Debug("detected kratos version", "version", version)
+
+	if major >= 1 {
+		return kratosAPIVersionV1, nil
+	}
+
+	return kratosAPIVersionV0alpha2, nil
+}